@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile reads the inputs of a JSON or YAML config file, keyed like the step inputs, e.g. `bump_type`.
+// YAML files are limited to a flat mapping of scalars, as every input is a string.
+func loadConfigFile(file string) (map[string]string, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(file) {
+	case ".json":
+		return parseJSONConfig(bytes)
+	case ".yml", ".yaml":
+		return parseYAMLConfig(string(bytes))
+	}
+
+	return nil, fmt.Errorf("Config file %s must have a .json, .yml or .yaml extension", file)
+}
+
+func parseJSONConfig(bytes []byte) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		switch typed := value.(type) {
+		case string:
+			values[key] = typed
+		case bool:
+			values[key] = strconv.FormatBool(typed)
+		case float64:
+			values[key] = strconv.FormatFloat(typed, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("Value of %s must be a string, number or boolean", key)
+		}
+	}
+
+	return values, nil
+}
+
+func parseYAMLConfig(content string) (map[string]string, error) {
+	values := map[string]string{}
+	for number, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") {
+			return nil, fmt.Errorf("Line %d: nested values are not supported, every input is a top-level key", number+1)
+		}
+
+		index := strings.Index(trimmed, ":")
+		if index == -1 {
+			return nil, fmt.Errorf("Line %d: expected `key: value`", number+1)
+		}
+		key := strings.TrimSpace(trimmed[:index])
+		raw := strings.TrimSpace(trimmed[index+1:])
+
+		value, err := unquoteYAMLScalar(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", number+1, err)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("Line %d: duplicate key %s", number+1, key)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func unquoteYAMLScalar(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) {
+		return strconv.Unquote(value)
+	}
+	if strings.HasPrefix(value, "'") {
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+	if index := strings.Index(value, " #"); index != -1 {
+		value = strings.TrimSpace(value[:index])
+	}
+	if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") || strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+		return "", fmt.Errorf("only scalar values are supported")
+	}
+
+	return value, nil
+}
+
+// configInputs looks up inputs in the environment first and falls back to the config file for the ones
+// that are undefined or empty, recording every key it was asked for so unknown keys of the file can be reported.
+type configInputs struct {
+	file  map[string]string
+	known map[string]bool
+}
+
+func (inputs *configInputs) get(key string) string {
+	inputs.known[key] = true
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return inputs.file[key]
+}
+
+func (inputs *configInputs) unknownKeys() []string {
+	unknown := []string{}
+	for key := range inputs.file {
+		if !inputs.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
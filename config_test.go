@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFiles(t *testing.T) {
+	want := map[string]string{"bump_type": "minor", "code_increment": "5", "do_merge": "false", "commit_message": "Release {version_name}"}
+
+	yaml, err := parseYAMLConfig("---\n# release config\nbump_type: minor\ncode_increment: 5 # every build\ndo_merge: 'false'\ncommit_message: \"Release {version_name}\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := parseJSONConfig([]byte(`{"bump_type": "minor", "code_increment": 5, "do_merge": false, "commit_message": "Release {version_name}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range map[string]map[string]string{"YAML": yaml, "JSON": json} {
+		if len(values) != len(want) {
+			t.Errorf("%s config = %v, want %v", name, values, want)
+		}
+		for key, value := range want {
+			if values[key] != value {
+				t.Errorf("%s config %s = %q, want %q", name, key, values[key], value)
+			}
+		}
+	}
+
+	for _, content := range []string{"release:\n  bump_type: minor\n", "bump_type minor\n", "a: 1\na: 2\n", "files: [a, b]\n"} {
+		if _, err := parseYAMLConfig(content); err == nil {
+			t.Errorf("parseYAMLConfig(%q) succeeded", content)
+		}
+	}
+	if _, err := parseJSONConfig([]byte(`{"file_glob": ["a", "b"]}`)); err == nil {
+		t.Error("parseJSONConfig() accepted a list value")
+	}
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bump.yml")
+	writeFixture(t, file, "mode: plan\ncode_increment: 5\ndo_merge: 'false'\nmodule: wear\n")
+
+	// empty inputs take the file's values
+	configs := testConfigs(t, map[string]string{"config_file": file, "mode": "", "code_increment": "", "do_merge": "", "module": ""})
+	if configs.Mode != "plan" || configs.CodeIncrement != "5" || configs.DoMerge != "false" || configs.Module != "wear" {
+		t.Errorf("configs = mode %s, code_increment %s, do_merge %s, module %s, want the file's values", configs.Mode, configs.CodeIncrement, configs.DoMerge, configs.Module)
+	}
+	// inputs not in the file keep their defaults
+	if configs.FileGlob != "build.gradle" {
+		t.Errorf("file_glob = %q, want the default", configs.FileGlob)
+	}
+
+	// set inputs override the file, also when they are at their step.yml defaults
+	configs = testConfigs(t, map[string]string{"config_file": file, "code_increment": "2", "module": "app"})
+	if configs.CodeIncrement != "2" || configs.Module != "app" || configs.Mode != "bump" || configs.DoMerge != "true" {
+		t.Errorf("configs = code_increment %s, module %s, mode %s, do_merge %s, want the inputs to override the file", configs.CodeIncrement, configs.Module, configs.Mode, configs.DoMerge)
+	}
+}
+
+func TestConfigFileUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bump.json")
+	writeFixture(t, file, `{"bump_typ": "minor", "mode": "plan"}`)
+	t.Setenv("config_file", file)
+
+	if _, err := createConfigsModel(); err == nil || !strings.Contains(err.Error(), "Unknown inputs in "+file+": bump_typ") {
+		t.Errorf("createConfigsModel() error = %v, want the unknown key reported", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+// doctorCheck is one line of the doctor report, a failed required check fails the step.
+type doctorCheck struct {
+	Name     string
+	Required bool
+	Run      func() (string, error)
+}
+
+func lookPathCheck(name string, required bool) doctorCheck {
+	return doctorCheck{
+		Name:     name,
+		Required: required,
+		Run: func() (string, error) {
+			return exec.LookPath(name)
+		},
+	}
+}
+
+func branchCheck(branch string) doctorCheck {
+	return doctorCheck{
+		Name:     "branch " + branch,
+		Required: true,
+		Run: func() (string, error) {
+			if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+				return "", fmt.Errorf("Branch %s does not exist", branch)
+			}
+			return "exists", nil
+		},
+	}
+}
+
+func doctorChecks(configs ConfigsModel) []doctorCheck {
+	pushes := configs.pushes()
+	searches := configs.VersionSource == "gradle" && configs.GradleFilePath == "" && configs.Module == "" &&
+		configs.ModuleBumpTypes == "" && (configs.CodeFile == "" || configs.NameFile == "")
+
+	checks := []doctorCheck{
+		{
+			Name:     "git",
+			Required: true,
+			Run: func() (string, error) {
+				return gitOutput("--version")
+			},
+		},
+		lookPathCheck("envman", configs.ExportEnvman == "true" && configs.EnvmanFailure == "fail"),
+		lookPathCheck("grep", searches),
+		{
+			Name:     "git repository",
+			Required: true,
+			Run: func() (string, error) {
+				return gitOutput("rev-parse", "--show-toplevel")
+			},
+		},
+		{
+			Name:     "version files",
+			Required: true,
+			Run: func() (string, error) {
+				files, err := findBuildGradleFiles(configs)
+				if err != nil {
+					return "", err
+				}
+
+				found := []string{}
+				for _, file := range files {
+					codeFile, nameFile := configs.versionFiles(file)
+					versions, err := getVersionsFromFiles(configs, codeFile, nameFile)
+					if err != nil {
+						return "", fmt.Errorf("%s: %s", file, err)
+					}
+					found = append(found, fmt.Sprintf("%s (%s, %d)", file, versions.Name, versions.Code))
+				}
+				return strings.Join(found, ", "), nil
+			},
+		},
+	}
+
+	if configs.CheckoutBranch != "" {
+		checks = append(checks, doctorCheck{
+			Name:     "checkout branch " + configs.CheckoutBranch,
+			Required: true,
+			Run: func() (string, error) {
+				if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/heads/"+configs.CheckoutBranch); err == nil {
+					return "exists", nil
+				}
+				out, err := gitOutput("ls-remote", "--heads", configs.primaryRemote(), configs.CheckoutBranch)
+				if err != nil {
+					return "", err
+				}
+				if out == "" {
+					return "", fmt.Errorf("Branch %s exists neither locally nor on %s", configs.CheckoutBranch, configs.primaryRemote())
+				}
+				return "fetched from " + configs.primaryRemote(), nil
+			},
+		})
+	}
+
+	for _, remote := range configs.gitRemotes() {
+		remote := remote
+		checks = append(checks, doctorCheck{
+			Name:     "remote " + remote,
+			Required: pushes,
+			Run: func() (string, error) {
+				return gitOutput("remote", "get-url", remote)
+			},
+		})
+	}
+
+	if configs.DoMerge == "true" {
+		checks = append(checks, branchCheck("master"))
+		if templatePrefix(configs.MergeBranch) == configs.MergeBranch {
+			checks = append(checks, branchCheck(configs.MergeBranch))
+		}
+		checks = append(checks, doctorCheck{
+			Name:     "full history",
+			Required: true,
+			Run: func() (string, error) {
+				shallow, err := isShallowRepository()
+				if err != nil {
+					return "", err
+				}
+				if shallow && configs.Unshallow != "true" {
+					return "", errors.New("Shallow clone, set unshallow to true or clone with full history")
+				}
+				if shallow {
+					return "shallow, fetched before merging", nil
+				}
+				return "complete", nil
+			},
+		})
+	}
+
+	return checks
+}
+
+// runDoctor prints a report of the environment and returns false if anything the configured flow needs is missing.
+func runDoctor(configs ConfigsModel) bool {
+	log.Info("Doctor:")
+
+	healthy := true
+	for _, check := range doctorChecks(configs) {
+		result, err := check.Run()
+		switch {
+		case err == nil:
+			log.Done("%s: %s", check.Name, result)
+		case check.Required:
+			healthy = false
+			log.Error("  %s: %s", check.Name, err)
+		default:
+			log.Warn("  %s: %s (not needed for this configuration)", check.Name, err)
+		}
+	}
+
+	return healthy
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/coreos/go-semver/semver"
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+func gitIdentityArgs(configs ConfigsModel) []string {
+	args := []string{}
+	if configs.GitConfigScope == "local" {
+		// the identity is already in the repository config
+		return args
+	}
+	if configs.GitAuthorName != "" {
+		args = append(args, "-c", "user.name="+configs.GitAuthorName)
+	}
+	if configs.GitAuthorEmail != "" {
+		args = append(args, "-c", "user.email="+configs.GitAuthorEmail)
+	}
+
+	return args
+}
+
+func gitCommitArgs(configs ConfigsModel, versions Versions) []string {
+	args := gitIdentityArgs(configs)
+	trailers := configs.commitTrailers()
+	if configs.Amend == "true" {
+		args = append(args, "commit", "--amend", "--no-edit")
+		for _, trailer := range trailers {
+			args = append(args, "--trailer", trailer)
+		}
+	} else {
+		args = append(args, "commit", "-m", commitMessage(configs, versions))
+		if configs.CommitBody != "" {
+			args = append(args, "-m", renderTemplate(configs.CommitBody, versions))
+		}
+		if len(trailers) > 0 {
+			// a separate -m becomes its own paragraph, which git recognizes as the trailer block
+			args = append(args, "-m", strings.Join(trailers, "\n"))
+		}
+	}
+	if configs.Signoff == "true" {
+		args = append(args, "-s")
+	}
+
+	return args
+}
+
+// gitCommitEnvs sets both dates of the commit to commit_date, if set, e.g. for reproducible builds.
+func gitCommitEnvs(configs ConfigsModel) []string {
+	if configs.CommitDate == "" {
+		return []string{}
+	}
+
+	return []string{"GIT_AUTHOR_DATE=" + configs.CommitDate, "GIT_COMMITTER_DATE=" + configs.CommitDate}
+}
+
+// gitPushBranchArgs never force pushes unconditionally, rewritten history is only pushed with a lease.
+func gitPushBranchArgs(configs ConfigsModel, remote, branch string) []string {
+	args := []string{"push"}
+	if configs.ForceWithLease == "true" {
+		args = append(args, "--force-with-lease")
+	}
+	if configs.SetUpstream == "true" {
+		return append(args, "-u", remote, branch)
+	}
+
+	return append(args, remote, "HEAD")
+}
+
+// gitMergeArgs optionally forces a merge commit, so the tag always points at the merge and not at the branch head.
+func gitMergeArgs(configs ConfigsModel, branch string, versions Versions) []string {
+	args := []string{"merge"}
+	if configs.MergeNoFF == "true" {
+		args = append(args, "--no-ff", "--no-edit")
+	}
+	if configs.MergeMessage != "" {
+		args = append(args, "-m", renderTemplate(configs.MergeMessage, versions))
+	}
+
+	return append(args, branch)
+}
+
+// gitPushReleaseArgs pushes master with the tags, either named explicitly or via --follow-tags,
+// which silently skips tags not reachable from the pushed commit.
+func gitPushReleaseArgs(configs ConfigsModel, remote string, tags []string) []string {
+	args := []string{"push", remote, "HEAD"}
+	if configs.PushTags != "true" || len(tags) == 0 {
+		return args
+	}
+
+	if configs.TagPushMode == "follow-tags" {
+		return append(args, "--follow-tags")
+	}
+
+	return append(args, tagRefspecs(configs, tags)...)
+}
+
+func gitPushTagsArgs(configs ConfigsModel, remote string, tags []string) []string {
+	return append([]string{"push", remote}, tagRefspecs(configs, tags)...)
+}
+
+// pushToRemotes runs the push built by args against every remote in order. A failing remote
+// doesn't stop the pushes to the others, all failures are returned together.
+func pushToRemotes(remotes []string, args func(remote string) []string) error {
+	failures := []string{}
+	for _, remote := range remotes {
+		if err := gitCommand(args(remote)...); err != nil {
+			log.Warn("Failed to push to %s: %s", remote, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", remote, err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// tagRefspecs force pushes only the tags, never the branch, when existing tags are overwritten.
+func tagRefspecs(configs ConfigsModel, tags []string) []string {
+	refspecs := []string{}
+	for _, tag := range tags {
+		refspec := "refs/tags/" + tag
+		if configs.OnExistingTag == "overwrite" {
+			refspec = "+" + refspec
+		}
+		refspecs = append(refspecs, refspec)
+	}
+
+	return refspecs
+}
+
+func gitTagArgs(configs ConfigsModel, tag string) []string {
+	args := []string{"tag", "-a"}
+	if configs.OnExistingTag == "overwrite" {
+		args = append(args, "-f")
+	}
+
+	return append(args, tag, "-m", tag)
+}
+
+// verifyTag checks that the tag exists and points at HEAD, so a push never silently goes out without it.
+func verifyTag(tag string) error {
+	target, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/tags/"+tag+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("Tag %s was not created", tag)
+	}
+
+	head, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if target != head {
+		return fmt.Errorf("Tag %s points at %s instead of HEAD %s", tag, target, head)
+	}
+
+	return nil
+}
+
+// configureLocalIdentity writes the author into the repository config, so every git command of the run,
+// including the merge, uses it. The returned function restores the previous values, it's safe to call twice.
+func configureLocalIdentity(configs ConfigsModel) (func(), error) {
+	previous := map[string]*string{}
+	restore := func() {
+		for key, value := range previous {
+			var err error
+			if value == nil {
+				err = gitCommand("config", "--local", "--unset", key)
+			} else {
+				err = gitCommand("config", "--local", key, *value)
+			}
+			if err != nil {
+				log.Warn("Failed to restore git config %s: %s", key, err)
+			}
+		}
+		previous = map[string]*string{}
+	}
+
+	for key, value := range map[string]string{
+		"user.name":  configs.GitAuthorName,
+		"user.email": configs.GitAuthorEmail,
+	} {
+		if value == "" {
+			continue
+		}
+
+		// git config exits with 1 if the key isn't set
+		if old, err := gitOutput("config", "--local", "--get", key); err == nil {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+
+		if err := gitCommand("config", "--local", key, value); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	return restore, nil
+}
+
+func gitCommand(args ...string) error {
+	return gitCommandWithEnvs([]string{}, args...)
+}
+
+// gitCommandWithEnvs runs git with envs, e.g. `GIT_AUTHOR_DATE=...`, added to the environment of the step.
+func gitCommandWithEnvs(envs []string, args ...string) error {
+	cmd := command.New("git", args...)
+	if len(envs) > 0 {
+		cmd.AppendEnvs(envs...)
+	}
+	cmd.SetStdout(log.Writer())
+	cmd.SetStderr(os.Stderr)
+	return cmd.Run()
+}
+
+func gitOutput(args ...string) (string, error) {
+	return command.New("git", args...).RunAndReturnTrimmedOutput()
+}
+
+// gitRefReader reads files as they are at the ref with `git show <ref>:<path>`, without checking it out.
+// Relative paths are relative to the working directory, like in the working tree.
+func gitRefReader(ref string) func(file string) ([]byte, error) {
+	return func(file string) ([]byte, error) {
+		path := "./" + filepath.ToSlash(filepath.Clean(file))
+		if filepath.IsAbs(file) {
+			root, err := gitOutput("rev-parse", "--show-toplevel")
+			if err != nil {
+				return nil, err
+			}
+			relative, err := filepath.Rel(root, file)
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.ToSlash(relative)
+		}
+
+		out, err := command.New("git", "show", ref+":"+path).GetCmd().Output()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s at %s: %s", file, ref, err)
+		}
+
+		return out, nil
+	}
+}
+
+// latestSemverTag returns the highest tag matching the glob pattern that the parser accepts
+// after stripping the scope, or a nil version if there is none. Tags are compared as semver,
+// git's sorting puts e.g. 1.0.0-rc.1 after 1.0.0.
+func latestSemverTag(parser VersionParser, pattern, scope string) (string, *semver.Version, error) {
+	if pattern == "" && scope != "" {
+		pattern = scope + "*"
+	}
+	args := []string{"tag", "--list"}
+	if pattern != "" {
+		args = append(args, pattern)
+	}
+	out, err := gitOutput(args...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	latestTag := ""
+	var latest *semver.Version
+	for _, tag := range strings.Split(out, "\n") {
+		if tag == "" || !strings.HasPrefix(tag, scope) {
+			continue
+		}
+		version, err := parser.Parse(strings.TrimPrefix(tag, scope))
+		if err != nil {
+			continue
+		}
+		if latest == nil || latest.LessThan(*version) {
+			latestTag, latest = tag, version
+		}
+	}
+
+	return latestTag, latest, nil
+}
+
+// describeTag returns the nearest tag reachable from HEAD that matches one of the globs and, if exclude is set,
+// doesn't match that glob, or an empty string if there is none.
+func describeTag(matches []string, exclude string) (string, error) {
+	args := []string{"describe", "--tags", "--abbrev=0"}
+	for _, match := range matches {
+		args = append(args, "--match", match)
+	}
+	if exclude != "" {
+		args = append(args, "--exclude", exclude)
+	}
+	tag, err := gitOutput(args...)
+	if err == nil {
+		return tag, nil
+	}
+
+	// describe fails without an error kind, tell the missing tags apart from other failures
+	reachable, listErr := gitOutput(append([]string{"tag", "--merged", "HEAD", "--list"}, matches...)...)
+	if listErr != nil {
+		return "", err
+	}
+	excluded := ""
+	if exclude != "" {
+		if excluded, listErr = gitOutput("tag", "--merged", "HEAD", "--list", exclude); listErr != nil {
+			return "", err
+		}
+	}
+	for _, tag := range strings.Split(reachable, "\n") {
+		if tag != "" && !sliceutil.IsStringInSlice(tag, strings.Split(excluded, "\n")) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// scopedTagCollisions returns the tags starting with the scope that the parser doesn't accept
+// after stripping it, e.g. `app-wear-1.0.0` for the scope `app-`.
+func scopedTagCollisions(parser VersionParser, scope string) ([]string, error) {
+	out, err := gitOutput("tag", "--list", scope+"*")
+	if err != nil {
+		return []string{}, err
+	}
+
+	collisions := []string{}
+	for _, tag := range strings.Split(out, "\n") {
+		if tag == "" {
+			continue
+		}
+		if _, err := parser.Parse(strings.TrimPrefix(tag, scope)); err != nil {
+			collisions = append(collisions, tag)
+		}
+	}
+
+	return collisions, nil
+}
+
+// waitForRemoteHead polls until the remote branch points at HEAD, so e.g. server-side hooks
+// see the pushed commit before the tag. It only warns on timeout, the tag is pushed anyway.
+func waitForRemoteHead(remote string, timeout time.Duration) {
+	branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		log.Warn("Failed to read current branch: %s", err)
+		return
+	}
+	sha, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		log.Warn("Failed to read HEAD: %s", err)
+		return
+	}
+
+	log.Detail("Waiting up to %s for %s to be visible on %s/%s", timeout, sha, remote, branch)
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := gitOutput("ls-remote", remote, "refs/heads/"+branch)
+		if err == nil && strings.HasPrefix(out, sha) {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warn("%s is not visible on %s/%s after %s, pushing the tag anyway", sha, remote, branch, timeout)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func isTagOnRemote(remote, tag string) (bool, error) {
+	out, err := gitOutput("ls-remote", "--tags", remote, "refs/tags/"+tag)
+	if err != nil {
+		return false, err
+	}
+
+	return out != "", nil
+}
+
+func isDetachedHead() (bool, error) {
+	branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	return branch == "HEAD", nil
+}
+
+func attachDetachedHead(configs ConfigsModel) error {
+	detached, err := isDetachedHead()
+	if err != nil {
+		return err
+	}
+
+	if !detached {
+		return nil
+	}
+
+	if configs.PushBranch == "" {
+		return errors.New("HEAD is detached, set push_branch to the branch the bump should be committed to")
+	}
+
+	log.Warn("HEAD is detached, attaching it to %s", configs.PushBranch)
+	return gitCommand("checkout", "-B", configs.PushBranch)
+}
+
+// checkoutBranch checks out the branch, fetching it from the primary remote if it doesn't exist locally,
+// e.g. when CI checked out a pull request merge ref.
+func checkoutBranch(configs ConfigsModel, branch string) error {
+	if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+		remote := configs.primaryRemote()
+		log.Detail("Branch %s does not exist locally, fetching it from %s", branch, remote)
+		if err := gitCommand("fetch", remote, branch+":"+branch); err != nil {
+			return fmt.Errorf("Branch %s exists neither locally nor on %s: %s", branch, remote, err)
+		}
+	}
+
+	return gitCommand("checkout", branch)
+}
+
+func mergeBranch(configs ConfigsModel, versions Versions) (string, error) {
+	branch := renderTemplate(configs.MergeBranch, versions)
+	if !isValidBranchName(branch) {
+		return "", fmt.Errorf("Merge branch %s is not a valid git branch name", branch)
+	}
+	if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+		return "", fmt.Errorf("Branch %s does not exist", branch)
+	}
+
+	return branch, nil
+}
+
+// mergeBackBranch resolves the branch that receives the bump after the release, it must differ from the current one.
+func mergeBackBranch(configs ConfigsModel, versions Versions) (string, error) {
+	target := renderTemplate(configs.MergeBackBranch, versions)
+	if !isValidBranchName(target) {
+		return "", fmt.Errorf("Merge back branch %s is not a valid git branch name", target)
+	}
+	if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/heads/"+target); err != nil {
+		return "", fmt.Errorf("Branch %s does not exist", target)
+	}
+
+	source, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if source == target {
+		return "", fmt.Errorf("Merge back branch %s is the branch the bump was made on", target)
+	}
+
+	return target, nil
+}
+
+// mergeBack merges the current branch into target and pushes it to the remotes, leaving HEAD on the current branch.
+func mergeBack(target string, remotes []string) error {
+	source, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	if err := gitCommand("checkout", target); err != nil {
+		return err
+	}
+
+	if err := gitCommand("merge", "--no-edit", source); err != nil {
+		if abortErr := gitCommand("merge", "--abort"); abortErr != nil {
+			log.Warn("Failed to abort merge: %s", abortErr)
+		}
+		return fmt.Errorf("Merging %s into %s has conflicts, merge it manually: %s", source, target, err)
+	}
+
+	if err := pushToRemotes(remotes, func(remote string) []string {
+		return []string{"push", remote, target}
+	}); err != nil {
+		return err
+	}
+
+	return gitCommand("checkout", source)
+}
+
+func exportStagedDiff(file string, files []string) error {
+	diff, err := gitOutput(append([]string{"diff", "--cached", "--"}, files...)...)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, []byte(diff+"\n"), 0644)
+}
+
+func isShallowRepository() (bool, error) {
+	shallow, err := gitOutput("rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return false, err
+	}
+
+	return shallow == "true", nil
+}
+
+// ensureFullHistory makes sure operations relying on history, like merging, see all commits.
+func ensureFullHistory(configs ConfigsModel) error {
+	shallow, err := isShallowRepository()
+	if err != nil {
+		return err
+	}
+
+	if !shallow {
+		return nil
+	}
+
+	if configs.Unshallow != "true" {
+		return errors.New("Repository is a shallow clone, merging needs the full history: clone with full depth or set unshallow to true")
+	}
+
+	log.Warn("Repository is a shallow clone, fetching the full history")
+	return gitCommand("fetch", "--unshallow", configs.primaryRemote())
+}
+
+func isHeadPushed() (bool, error) {
+	branches, err := gitOutput("branch", "-r", "--contains", "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	return branches != "", nil
+}
+
+func isLastCommitBump(configs ConfigsModel) (bool, error) {
+	subject, err := gitOutput("log", "-1", "--pretty=%s")
+	if err != nil {
+		return false, err
+	}
+
+	return templateRegexp(configs.commitMessageTemplate()).MatchString(subject), nil
+}
+
+// bumpTypeFromCommit returns the lower-cased value of the key trailer of the last commit, e.g. `minor`
+// for `Bump: minor`, or an empty string if there is none. Keys match case-insensitively like in git.
+func bumpTypeFromCommit(key string) (string, error) {
+	message, err := gitOutput("log", "-1", "--pretty=%B")
+	if err != nil {
+		return "", err
+	}
+
+	// trailers are the last paragraph of the message
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		return "", nil
+	}
+
+	value := ""
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		index := strings.Index(line, ":")
+		if index == -1 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:index]), key) {
+			// the last one wins, e.g. after a fixup
+			value = strings.ToLower(strings.TrimSpace(line[index+1:]))
+		}
+	}
+
+	return value, nil
+}
+
+// realPath resolves the symlinks of the file, a file that doesn't exist yet, e.g. a write_version_file
+// the bump creates, is resolved in its nearest existing directory.
+func realPath(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		dir, err := realPath(filepath.Dir(abs))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, filepath.Base(abs)), nil
+	}
+
+	return path, err
+}
+
+// changedFilesExcept lists tracked files with staged or unstaged changes, other than the given files.
+func changedFilesExcept(files []string) ([]string, error) {
+	root, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return []string{}, err
+	}
+
+	targets := map[string]bool{}
+	for _, file := range files {
+		path, err := realPath(file)
+		if err != nil {
+			return []string{}, err
+		}
+		targets[path] = true
+	}
+
+	out, err := gitOutput("diff", "HEAD", "--name-only")
+	if err != nil {
+		return []string{}, err
+	}
+
+	changed := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		file := strings.TrimSpace(line)
+		if file == "" {
+			continue
+		}
+
+		path, err := realPath(filepath.Join(root, file))
+		if err != nil {
+			// deleted files can't be resolved
+			path = filepath.Join(root, file)
+		}
+		if !targets[path] {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed, nil
+}
+
+// changedFilesSnapshot maps the tracked files with staged or unstaged changes, relative to the repository
+// root, to their content, nil for a deleted file.
+func changedFilesSnapshot() (map[string][]byte, error) {
+	root, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return map[string][]byte{}, err
+	}
+	changed, err := changedFilesExcept([]string{})
+	if err != nil {
+		return map[string][]byte{}, err
+	}
+
+	snapshot := map[string][]byte{}
+	for _, file := range changed {
+		content, err := ioutil.ReadFile(filepath.Join(root, file))
+		if err != nil && !os.IsNotExist(err) {
+			return map[string][]byte{}, err
+		}
+		snapshot[file] = content
+	}
+
+	return snapshot, nil
+}
+
+// filesChangedSince lists the tracked files changed since the snapshot was taken, e.g. by a command, leaving
+// out the files that were already changed before and stayed as they were.
+func filesChangedSince(before map[string][]byte) ([]string, error) {
+	after, err := changedFilesSnapshot()
+	if err != nil {
+		return []string{}, err
+	}
+
+	changed := []string{}
+	for file, content := range after {
+		if old, ok := before[file]; !ok || (old == nil) != (content == nil) || !bytes.Equal(old, content) {
+			changed = append(changed, file)
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok {
+			// changed back to the committed content
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed, nil
+}
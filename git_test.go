@@ -0,0 +1,1067 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in the working directory and returns its trimmed output, failing the test on error.
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// newTestRepo changes to a new repository with app/build.gradle committed on master and develop checked out.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(dir, ".no-global-gitconfig"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	runGit(t, "init", "-q", "-b", "master")
+	runGit(t, "config", "user.name", "Test")
+	runGit(t, "config", "user.email", "test@example.com")
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Initial commit")
+	runGit(t, "checkout", "-q", "-b", "develop")
+
+	return dir
+}
+
+// addTestRemote adds a bare repository as the remote and pushes master and develop to it.
+func addTestRemote(t *testing.T, name string) string {
+	t.Helper()
+
+	remote := filepath.Join(t.TempDir(), name+".git")
+	runGit(t, "init", "-q", "--bare", "-b", "master", remote)
+	runGit(t, "remote", "add", name, remote)
+	runGit(t, "push", "-q", name, "master", "develop")
+
+	return remote
+}
+
+func TestAttachDetachedHead(t *testing.T) {
+	newTestRepo(t)
+	head := runGit(t, "rev-parse", "HEAD")
+	runGit(t, "checkout", "-q", "--detach")
+
+	if err := attachDetachedHead(testConfigs(t, nil)); err == nil || !strings.Contains(err.Error(), "set push_branch") {
+		t.Fatalf("attachDetachedHead() without push_branch error = %v, want it to ask for push_branch", err)
+	}
+
+	if err := attachDetachedHead(testConfigs(t, map[string]string{"push_branch": "release"})); err != nil {
+		t.Fatalf("attachDetachedHead() = %s", err)
+	}
+	if branch := runGit(t, "rev-parse", "--abbrev-ref", "HEAD"); branch != "release" {
+		t.Errorf("HEAD is on %s, want release", branch)
+	}
+	if sha := runGit(t, "rev-parse", "HEAD"); sha != head {
+		t.Errorf("HEAD moved from %s to %s", head, sha)
+	}
+
+	// an attached HEAD is left as is
+	if err := attachDetachedHead(testConfigs(t, map[string]string{"push_branch": "other"})); err != nil {
+		t.Fatalf("attachDetachedHead() = %s", err)
+	}
+	if branch := runGit(t, "rev-parse", "--abbrev-ref", "HEAD"); branch != "release" {
+		t.Errorf("HEAD is on %s, want release", branch)
+	}
+}
+
+func TestIsLastCommitBump(t *testing.T) {
+	newTestRepo(t)
+
+	for _, test := range []struct {
+		message  string
+		template string
+		isBump   bool
+	}{
+		{"Bump version to 1.2.4", "Bump version to {version_name}", true},
+		{"Fix crash", "Bump version to {version_name}", false},
+		{"1.2.4: release bump", "{version_name}: release bump", true},
+		{"Fix crash", "{version_name}: release bump", false},
+	} {
+		runGit(t, "commit", "-q", "--allow-empty", "-m", test.message)
+
+		isBump, err := isLastCommitBump(testConfigs(t, map[string]string{"commit_message": test.template}))
+		if err != nil {
+			t.Fatalf("isLastCommitBump() = %s", err)
+		}
+		if isBump != test.isBump {
+			t.Errorf("isLastCommitBump() after %q with template %q = %t, want %t", test.message, test.template, isBump, test.isBump)
+		}
+	}
+}
+
+func TestForceWithLeaseNeverForcesTags(t *testing.T) {
+	for _, test := range []struct {
+		onExistingTag string
+		tagRefspec    string
+	}{
+		{"fail", "refs/tags/1.2.4"},
+		{"skip", "refs/tags/1.2.4"},
+		{"overwrite", "+refs/tags/1.2.4"},
+	} {
+		configs := testConfigs(t, map[string]string{"force_with_lease": "true", "on_existing_tag": test.onExistingTag})
+
+		branchArgs := gitPushBranchArgs(configs, "origin", "develop")
+		if want := []string{"push", "--force-with-lease", "origin", "HEAD"}; !equalStrings(branchArgs, want) {
+			t.Errorf("gitPushBranchArgs() = %v, want %v", branchArgs, want)
+		}
+		for _, arg := range branchArgs {
+			if arg == "--force" || arg == "-f" {
+				t.Errorf("gitPushBranchArgs() = %v force pushes unconditionally", branchArgs)
+			}
+		}
+
+		if tagArgs := gitPushTagsArgs(configs, "origin", []string{"1.2.4"}); !equalStrings(tagArgs, []string{"push", "origin", test.tagRefspec}) {
+			t.Errorf("gitPushTagsArgs() with on_existing_tag %s = %v, want %s", test.onExistingTag, tagArgs, test.tagRefspec)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
+
+func TestPushTagsFalseKeepsTagLocal(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"push_tags": "false"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if tag := runGit(t, "tag", "--list", "1.2.4"); tag != "1.2.4" {
+		t.Errorf("local tags = %q, want 1.2.4", tag)
+	}
+	if remoteTags := runGit(t, "ls-remote", "--tags", "origin"); remoteTags != "" {
+		t.Errorf("remote tags = %q, want none", remoteTags)
+	}
+	// the branches are still pushed
+	if develop := runGit(t, "rev-parse", "origin/develop"); develop != runGit(t, "rev-parse", "develop") {
+		t.Errorf("origin/develop is at %s, not at the local bump", develop)
+	}
+}
+
+func TestDoPushTagsIsIgnoredWithAWarning(t *testing.T) {
+	newTestRepo(t)
+	fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "do_push_tags": "false"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "do_push_tags (false) is not an input of the step and is ignored, set push_tags instead") {
+		t.Errorf("step output doesn't warn about do_push_tags:\n%s", out)
+	}
+}
+
+func TestEnsureFullHistory(t *testing.T) {
+	newTestRepo(t)
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Second commit")
+	remote := addTestRemote(t, "origin")
+
+	// a CI like clone of the remote with the latest commit only
+	clone := filepath.Join(t.TempDir(), "clone")
+	runGit(t, "clone", "-q", "--depth", "1", "--branch", "develop", "file://"+remote, clone)
+	t.Chdir(clone)
+
+	shallow, err := isShallowRepository()
+	if err != nil || !shallow {
+		t.Fatalf("isShallowRepository() = %t, %v, want true", shallow, err)
+	}
+
+	if err := ensureFullHistory(testConfigs(t, map[string]string{"unshallow": "false"})); err == nil || !strings.Contains(err.Error(), "set unshallow to true") {
+		t.Fatalf("ensureFullHistory() with unshallow false error = %v, want it to ask for unshallow", err)
+	}
+	if count := runGit(t, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("history has %s commits after a failed check, want it still shallow", count)
+	}
+
+	if err := ensureFullHistory(testConfigs(t, map[string]string{"unshallow": "true"})); err != nil {
+		t.Fatalf("ensureFullHistory() = %s", err)
+	}
+	if shallow, err := isShallowRepository(); err != nil || shallow {
+		t.Errorf("isShallowRepository() after unshallowing = %t, %v, want false", shallow, err)
+	}
+	if count := runGit(t, "rev-list", "--count", "HEAD"); count != "2" {
+		t.Errorf("history has %s commits, want the full 2", count)
+	}
+
+	// a full clone is left as is
+	if err := ensureFullHistory(testConfigs(t, map[string]string{"unshallow": "false"})); err != nil {
+		t.Errorf("ensureFullHistory() on a full clone = %s", err)
+	}
+}
+
+func TestGitMergeArgs(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 13}
+	for _, test := range []struct {
+		mergeNoFF string
+		want      []string
+	}{
+		{"false", []string{"merge", "develop"}},
+		{"true", []string{"merge", "--no-ff", "--no-edit", "develop"}},
+	} {
+		args := gitMergeArgs(testConfigs(t, map[string]string{"merge_no_ff": test.mergeNoFF}), "develop", versions)
+		if !equalStrings(args, test.want) {
+			t.Errorf("gitMergeArgs() with merge_no_ff %s = %v, want %v", test.mergeNoFF, args, test.want)
+		}
+	}
+}
+
+func TestMergeNoFFNeedsMerge(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	if _, err := testConfigs(t, map[string]string{"merge_no_ff": "true"}).validate(); err != nil {
+		t.Errorf("validate() with the merge = %s", err)
+	}
+	if _, err := testConfigs(t, map[string]string{"merge_no_ff": "true", "do_merge": "false"}).validate(); err == nil || !strings.Contains(err.Error(), "no-ff") {
+		t.Errorf("validate() without the merge error = %v, want merge_no_ff rejected", err)
+	}
+}
+
+func TestGitPushReleaseArgs(t *testing.T) {
+	for _, test := range []struct {
+		overrides map[string]string
+		tags      []string
+		want      []string
+	}{
+		{map[string]string{"tag_push_mode": "explicit"}, []string{"1.2.4"}, []string{"push", "origin", "HEAD", "refs/tags/1.2.4"}},
+		{map[string]string{"tag_push_mode": "explicit"}, []string{"1.2.4", "code-13"}, []string{"push", "origin", "HEAD", "refs/tags/1.2.4", "refs/tags/code-13"}},
+		{map[string]string{"tag_push_mode": "follow-tags"}, []string{"1.2.4"}, []string{"push", "origin", "HEAD", "--follow-tags"}},
+		{map[string]string{"tag_push_mode": "explicit"}, []string{}, []string{"push", "origin", "HEAD"}},
+		{map[string]string{"tag_push_mode": "explicit", "push_tags": "false"}, []string{"1.2.4"}, []string{"push", "origin", "HEAD"}},
+	} {
+		if args := gitPushReleaseArgs(testConfigs(t, test.overrides), "origin", test.tags); !equalStrings(args, test.want) {
+			t.Errorf("gitPushReleaseArgs() with %v and tags %v = %v, want %v", test.overrides, test.tags, args, test.want)
+		}
+	}
+}
+
+func TestGitCommitArgsTrailers(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 13}
+	configs := testConfigs(t, map[string]string{"commit_trailers": "Skip-Release: true\n\n  Release-Type: patch  \n"})
+
+	want := []string{"commit", "-m", "Bump version to 1.2.4", "-m", "Skip-Release: true\nRelease-Type: patch"}
+	if args := gitCommitArgs(configs, versions); !equalStrings(args, want) {
+		t.Errorf("gitCommitArgs() = %q, want %q", args, want)
+	}
+
+	amend := testConfigs(t, map[string]string{"commit_trailers": "Skip-Release: true", "amend": "true"})
+	want = []string{"commit", "--amend", "--no-edit", "--trailer", "Skip-Release: true"}
+	if args := gitCommitArgs(amend, versions); !equalStrings(args, want) {
+		t.Errorf("gitCommitArgs() with amend = %q, want %q", args, want)
+	}
+
+	// git parses the last paragraph as the trailers of the commit
+	newTestRepo(t)
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Work")
+	if err := gitCommand(append(gitCommitArgs(amend, versions), "--allow-empty")...); err != nil {
+		t.Fatal(err)
+	}
+	if value := runGit(t, "log", "-1", "--format=%(trailers:key=Skip-Release,valueonly)"); value != "true" {
+		t.Errorf("Skip-Release trailer of the amended commit = %q, want true", value)
+	}
+
+	if err := gitCommand(append(gitCommitArgs(configs, versions), "--allow-empty")...); err != nil {
+		t.Fatal(err)
+	}
+	if value := runGit(t, "log", "-1", "--format=%(trailers:key=Release-Type,valueonly)"); value != "patch" {
+		t.Errorf("Release-Type trailer = %q, want patch", value)
+	}
+}
+
+// pushFromOtherClone clones the remote, lets change edit the clone and pushes the result to develop,
+// like a concurrent run of another pipeline.
+func pushFromOtherClone(t *testing.T, remote string, change func()) {
+	t.Helper()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := filepath.Join(t.TempDir(), "other")
+	runGit(t, "clone", "-q", "--branch", "develop", remote, clone)
+	t.Chdir(clone)
+	runGit(t, "config", "user.name", "Other")
+	runGit(t, "config", "user.email", "other@example.com")
+	change()
+	runGit(t, "commit", "-q", "-am", "Concurrent change")
+	runGit(t, "push", "-q", "origin", "develop")
+	t.Chdir(dir)
+}
+
+func TestReleaseRetriesRebaseOntoMovedRemote(t *testing.T) {
+	for _, test := range []struct {
+		retries string
+		fails   bool
+	}{
+		{"0", true},
+		{"1", false},
+	} {
+		t.Run("release_retries "+test.retries, func(t *testing.T) {
+			newTestRepo(t)
+			remote := addTestRemote(t, "origin")
+			fakeEnvman(t)
+
+			// another run bumped the versions on the remote meanwhile
+			pushFromOtherClone(t, remote, func() {
+				writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode 13", `"1.2.3"`, `"1.2.4"`).Replace(buildGradleFixture))
+			})
+
+			out, err := runStep(t, map[string]string{"release_retries": test.retries, "do_merge": "false", "do_tag": "false"})
+			if test.fails {
+				if err == nil {
+					t.Fatalf("step pushed onto the moved remote without retries:\n%s", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("step failed: %s\n%s", err, out)
+			}
+
+			// the bump was reapplied on top of the concurrent one instead of overwriting it
+			runGit(t, "fetch", "-q", "origin")
+			content := runGit(t, "show", "origin/develop:app/build.gradle")
+			if !strings.Contains(content, "versionCode 14") || !strings.Contains(content, `versionName "1.2.5"`) {
+				t.Errorf("remote app/build.gradle =\n%s\nwant versionCode 14 and versionName 1.2.5", content)
+			}
+			if subjects := runGit(t, "log", "--format=%s", "origin/develop"); subjects != "Bump version to 1.2.5\nConcurrent change\nInitial commit" {
+				t.Errorf("remote history =\n%s", subjects)
+			}
+		})
+	}
+}
+
+func TestReleaseRetriesRerenderMerge(t *testing.T) {
+	newTestRepo(t)
+	for _, release := range []string{"1.2.4", "1.2.5"} {
+		runGit(t, "checkout", "-q", "-b", "release/"+release, "develop")
+		runGit(t, "commit", "-q", "--allow-empty", "-m", "Release "+release+" notes")
+	}
+	runGit(t, "checkout", "-q", "develop")
+	remote := addTestRemote(t, "origin")
+	fakeEnvman(t)
+
+	// another run bumped the versions on the remote meanwhile, the rebase bumps to 1.2.5
+	pushFromOtherClone(t, remote, func() {
+		writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode 13", `"1.2.3"`, `"1.2.4"`).Replace(buildGradleFixture))
+	})
+
+	out, err := runStep(t, map[string]string{
+		"release_retries": "1",
+		"do_tag":          "false",
+		"merge_branch":    "release/{version_name}",
+		"merge_no_ff":     "true",
+		"merge_message":   "Merge release {version_name}",
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if subject := runGit(t, "log", "-1", "--format=%s", "master"); subject != "Merge release 1.2.5" {
+		t.Errorf("merge commit subject = %q, want the merge_message of the rebased versions", subject)
+	}
+	if subjects := runGit(t, "log", "--format=%s", "master"); !strings.Contains(subjects, "Release 1.2.5 notes") || strings.Contains(subjects, "Release 1.2.4 notes") {
+		t.Errorf("master history =\n%s\nwant release/1.2.5 merged, not release/1.2.4", subjects)
+	}
+}
+
+func TestModuleBumpsTagEveryModule(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "wear/build.gradle", buildGradleFixture)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Add wear")
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+
+	// both modules are at 1.2.3, each is tagged in its own namespace
+	out, err := runStep(t, map[string]string{"module_bump_types": "app=patch,wear=patch"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if tags := runGit(t, "ls-remote", "--tags", "--refs", "origin"); !strings.Contains(tags, "refs/tags/app-1.2.4") || !strings.Contains(tags, "refs/tags/wear-1.2.4") {
+		t.Errorf("remote tags =\n%s\nwant app-1.2.4 and wear-1.2.4", tags)
+	}
+
+	// an existing tag of the second module fails the run before the first module is pushed
+	newTestRepo(t)
+	writeFixture(t, "wear/build.gradle", buildGradleFixture)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Add wear")
+	remote := addTestRemote(t, "origin")
+	runGit(t, "tag", "wear-1.2.4")
+	runGit(t, "push", "-q", "origin", "wear-1.2.4")
+	runGit(t, "tag", "-d", "wear-1.2.4")
+	develop := runGit(t, "rev-parse", "develop")
+
+	out, err = runStep(t, map[string]string{"module_bump_types": "app=patch,wear=patch"})
+	if err == nil || !strings.Contains(out, "Tag wear-1.2.4 already exists on origin") {
+		t.Fatalf("step with an existing wear tag error = %v, want it refused:\n%s", err, out)
+	}
+	if pushed := runGit(t, "--git-dir", remote, "rev-parse", "develop"); pushed != develop {
+		t.Errorf("remote develop moved to %s, want nothing pushed", pushed)
+	}
+	if tags := runGit(t, "ls-remote", "--tags", "--refs", "origin"); strings.Contains(tags, "app-1.2.4") {
+		t.Errorf("remote tags =\n%s\nwant app-1.2.4 not pushed", tags)
+	}
+}
+
+func TestGitPushBranchArgsSetUpstream(t *testing.T) {
+	for _, test := range []struct {
+		overrides map[string]string
+		want      []string
+	}{
+		{nil, []string{"push", "origin", "HEAD"}},
+		{map[string]string{"set_upstream": "true"}, []string{"push", "-u", "origin", "bump/1.2.4"}},
+		{map[string]string{"set_upstream": "true", "force_with_lease": "true"}, []string{"push", "--force-with-lease", "-u", "origin", "bump/1.2.4"}},
+	} {
+		if args := gitPushBranchArgs(testConfigs(t, test.overrides), "origin", "bump/1.2.4"); !equalStrings(args, test.want) {
+			t.Errorf("gitPushBranchArgs() with %v = %v, want %v", test.overrides, args, test.want)
+		}
+	}
+}
+
+func TestSetUpstreamPush(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	runGit(t, "checkout", "-q", "-b", "bump/1.2.4")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Bump")
+
+	if err := gitCommand(gitPushBranchArgs(testConfigs(t, map[string]string{"set_upstream": "true"}), "origin", "bump/1.2.4")...); err != nil {
+		t.Fatal(err)
+	}
+	if upstream := runGit(t, "rev-parse", "--abbrev-ref", "@{upstream}"); upstream != "origin/bump/1.2.4" {
+		t.Errorf("upstream = %s, want origin/bump/1.2.4", upstream)
+	}
+}
+
+func TestGitCommitArgsBody(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 13}
+	configs := testConfigs(t, map[string]string{"commit_body": "Release {version_name} ({version_code})\n\n- crash fixes", "commit_trailers": "Skip-Release: true"})
+
+	want := []string{"commit", "-m", "Bump version to 1.2.4", "-m", "Release 1.2.4 (13)\n\n- crash fixes", "-m", "Skip-Release: true"}
+	if args := gitCommitArgs(configs, versions); !equalStrings(args, want) {
+		t.Errorf("gitCommitArgs() = %q, want %q", args, want)
+	}
+
+	newTestRepo(t)
+	if err := gitCommand(append(gitCommitArgs(configs, versions), "--allow-empty")...); err != nil {
+		t.Fatal(err)
+	}
+	if subject := runGit(t, "log", "-1", "--format=%s"); subject != "Bump version to 1.2.4" {
+		t.Errorf("subject = %q", subject)
+	}
+	if body := runGit(t, "log", "-1", "--format=%b"); body != "Release 1.2.4 (13)\n\n- crash fixes\n\nSkip-Release: true" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestLatestSemverTagSortsBySemver(t *testing.T) {
+	newTestRepo(t)
+	for _, tag := range []string{"v1.9.0", "v1.10.0", "v1.10.1", "v1.10.1-rc.1", "v2.0.0-beta.1", "nightly", "app-v3.0.0"} {
+		runGit(t, "tag", tag)
+	}
+	parser := versionParserFor(testConfigs(t, map[string]string{"version_format": "v-semver"}))
+
+	for _, test := range []struct {
+		pattern string
+		scope   string
+		want    string
+	}{
+		{"v*", "", "v2.0.0-beta.1"},
+		{"v1.*", "", "v1.10.1"},
+		{"v1.9*", "", "v1.9.0"},
+		{"", "app-", "app-v3.0.0"},
+	} {
+		tag, latest, err := latestSemverTag(parser, test.pattern, test.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag != test.want || latest == nil {
+			t.Errorf("latestSemverTag(%q, %q) = %s, want %s", test.pattern, test.scope, tag, test.want)
+		}
+	}
+
+	tag, latest, err := latestSemverTag(parser, "release-*", "")
+	if err != nil || tag != "" || latest != nil {
+		t.Errorf("latestSemverTag() without matching tags = %s, %v, %v, want none", tag, latest, err)
+	}
+}
+
+func TestScopedTagCollisions(t *testing.T) {
+	newTestRepo(t)
+	for _, tag := range []string{"app-1.0.0", "app-1.1.0", "app-wear-1.0.0", "wear-1.0.0", "1.0.0"} {
+		runGit(t, "tag", tag)
+	}
+	parser := versionParserFor(testConfigs(t, nil))
+
+	collisions, err := scopedTagCollisions(parser, "app-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(collisions, []string{"app-wear-1.0.0"}) {
+		t.Errorf("scopedTagCollisions(app-) = %v, want app-wear-1.0.0", collisions)
+	}
+
+	if collisions, err := scopedTagCollisions(parser, "wear-"); err != nil || len(collisions) != 0 {
+		t.Errorf("scopedTagCollisions(wear-) = %v, %v, want none", collisions, err)
+	}
+
+	tag, _, err := latestSemverTag(parser, "", "app-")
+	if err != nil || tag != "app-1.1.0" {
+		t.Errorf("latestSemverTag() in scope app- = %s, %v, want app-1.1.0", tag, err)
+	}
+}
+
+func TestScopedTagBumpStagesOnlyTheApp(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "wear/notes.txt", "notes\n")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Add wear")
+	writeFixture(t, "wear/notes.txt", "work in progress\n")
+	fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"tag_scope": "app-v", "do_push_branch": "false", "do_merge": "false", "push_tags": "false"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if tags := runGit(t, "tag", "--list"); tags != "app-v1.2.4" {
+		t.Errorf("tags = %q, want app-v1.2.4", tags)
+	}
+	if files := runGit(t, "show", "--name-only", "--format=", "HEAD"); files != "app/build.gradle" {
+		t.Errorf("bump commit changed %q, want app/build.gradle only", files)
+	}
+	if status := runGit(t, "status", "--porcelain"); status != "M wear/notes.txt" {
+		t.Errorf("status = %q, want the wear change left unstaged", status)
+	}
+}
+
+func TestNewReleaseTagsOnRemote(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	// another run pushed the tag, there is no local one
+	runGit(t, "push", "-q", "origin", "HEAD:refs/tags/1.2.4")
+	versions := Versions{Name: "1.2.4", Code: 13}
+
+	for _, test := range []struct {
+		onExistingTag string
+		want          []string
+		err           string
+	}{
+		{"fail", nil, "Tag 1.2.4 already exists on origin"},
+		{"skip", []string{}, ""},
+		{"overwrite", []string{"1.2.4"}, ""},
+	} {
+		tags, err := newReleaseTags(testConfigs(t, map[string]string{"on_existing_tag": test.onExistingTag}), versions)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("newReleaseTags() with on_existing_tag %s error = %v, want %q", test.onExistingTag, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !equalStrings(tags, test.want) {
+			t.Errorf("newReleaseTags() with on_existing_tag %s = %v, %v, want %v", test.onExistingTag, tags, err, test.want)
+		}
+	}
+
+	// without pushing tags the remote isn't asked
+	tags, err := newReleaseTags(testConfigs(t, map[string]string{"push_tags": "false"}), versions)
+	if err != nil || !equalStrings(tags, []string{"1.2.4"}) {
+		t.Errorf("newReleaseTags() without pushing tags = %v, %v, want 1.2.4", tags, err)
+	}
+
+	runGit(t, "tag", "1.2.4", "HEAD")
+	if _, err := newReleaseTags(testConfigs(t, map[string]string{"push_tags": "false"}), versions); err == nil || !strings.Contains(err.Error(), "already exists locally") {
+		t.Errorf("newReleaseTags() with a local tag error = %v, want it to exist locally", err)
+	}
+}
+
+func TestBumpTypeFromCommit(t *testing.T) {
+	newTestRepo(t)
+
+	for _, test := range []struct {
+		message string
+		want    string
+	}{
+		{"Add login\n\nBump: minor", "minor"},
+		{"Add login\n\nSigned-off-by: Test <test@example.com>\nBump: Major", "major"},
+		{"Add login\n\nbump: patch", "patch"},
+		{"Add login\n\nBump: minor\nBump: patch", "patch"},
+		{"Add login", ""},
+		{"Bump: minor", ""},
+		{"Add login\n\nBump: minor\n\nReviewed in the meeting", ""},
+		{"Add login\n\nBumped: minor", ""},
+	} {
+		runGit(t, "commit", "-q", "--allow-empty", "-m", test.message)
+
+		bumpType, err := bumpTypeFromCommit("Bump")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bumpType != test.want {
+			t.Errorf("bumpTypeFromCommit() of %q = %q, want %q", test.message, bumpType, test.want)
+		}
+	}
+}
+
+func TestBumpTypeFromCommitStep(t *testing.T) {
+	for _, test := range []struct {
+		message string
+		want    string
+		fails   bool
+	}{
+		{"Add login\n\nBump: minor", "1.3.0", false},
+		{"Add login", "1.2.4", false},
+		{"Add login\n\nBump: huge", "", true},
+	} {
+		newTestRepo(t)
+		fakeEnvman(t)
+		runGit(t, "commit", "-q", "--allow-empty", "-m", test.message)
+
+		out, err := runStep(t, map[string]string{"bump_type": "from-commit", "mode": "export_only", "version_output_file": "version.env"})
+		if test.fails {
+			if err == nil || !strings.Contains(out, "Bump trailer of the last commit is huge") {
+				t.Errorf("step with %q error = %v, want the invalid trailer reported:\n%s", test.message, err, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("step failed: %s\n%s", err, out)
+		}
+		if name := strings.TrimPrefix(strings.Split(readFixture(t, "version.env"), "\n")[0], "BUMP_VERSION_NAME="); name != test.want {
+			t.Errorf("bump with %q = %s, want %s", test.message, name, test.want)
+		}
+	}
+}
+
+func TestPushToMultipleRemotes(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	addTestRemote(t, "mirror")
+	fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"git_remotes": "origin, mirror"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	head := runGit(t, "rev-parse", "develop")
+	for _, remote := range []string{"origin", "mirror"} {
+		if develop := runGit(t, "rev-parse", remote+"/develop"); develop != head {
+			t.Errorf("%s/develop is at %s, not at the local bump", remote, develop)
+		}
+		if tags := runGit(t, "ls-remote", "--tags", remote, "1.2.4"); !strings.HasSuffix(tags, "refs/tags/1.2.4") {
+			t.Errorf("tags on %s = %q, want 1.2.4", remote, tags)
+		}
+	}
+}
+
+func TestPushToRemotesAggregatesFailures(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	runGit(t, "remote", "add", "gone", filepath.Join(t.TempDir(), "gone.git"))
+	addTestRemote(t, "mirror")
+	runGit(t, "tag", "1.2.4")
+
+	pushed := []string{}
+	err := pushToRemotes([]string{"origin", "gone", "mirror"}, func(remote string) []string {
+		pushed = append(pushed, remote)
+		return gitPushTagsArgs(testConfigs(t, nil), remote, []string{"1.2.4"})
+	})
+
+	if !equalStrings(pushed, []string{"origin", "gone", "mirror"}) {
+		t.Errorf("pushed to %v, want every remote in order", pushed)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "gone: ") || strings.Contains(err.Error(), "origin") || strings.Contains(err.Error(), "mirror") {
+		t.Errorf("pushToRemotes() error = %v, want only the failure of gone", err)
+	}
+	for _, remote := range []string{"origin", "mirror"} {
+		if tags := runGit(t, "ls-remote", "--tags", remote); !strings.HasSuffix(tags, "refs/tags/1.2.4") {
+			t.Errorf("tags on %s = %q, want 1.2.4 pushed despite the failure", remote, tags)
+		}
+	}
+}
+
+func TestGitRemotesValidation(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"git_remotes": "origin,mirror"})
+	if err == nil || !strings.Contains(out, "Remote mirror of git_remotes does not exist") {
+		t.Errorf("step error = %v, want the missing remote refused:\n%s", err, out)
+	}
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle changed with a missing remote:\n%s", content)
+	}
+}
+
+func TestCheckoutBranchBeforeFind(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	// the release branch exists only on the remote and carries other versions than develop
+	runGit(t, "checkout", "-q", "-b", "release/2.0")
+	writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode 40", `"1.2.3"`, `"2.0.0"`).Replace(buildGradleFixture))
+	runGit(t, "commit", "-q", "-am", "Release 2.0")
+	runGit(t, "push", "-q", "origin", "release/2.0")
+	runGit(t, "checkout", "-q", "develop")
+	runGit(t, "branch", "-q", "-D", "release/2.0")
+	exports := fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "checkout_branch": "release/2.0"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if branch := runGit(t, "rev-parse", "--abbrev-ref", "HEAD"); branch != "release/2.0" {
+		t.Errorf("checked out %s, want release/2.0", branch)
+	}
+	if got := exports(); got["BUMP_VERSION_NAME"] != "2.0.1" || got["BUMP_VERSION_CODE"] != "41" {
+		t.Errorf("exported %v, want the bump of release/2.0's 2.0.0 (40)", got)
+	}
+
+	out, err = runStep(t, map[string]string{"mode": "export_only", "checkout_branch": "missing"})
+	if err == nil || !strings.Contains(out, "Branch missing exists neither locally nor on origin") {
+		t.Errorf("step with a missing branch error = %v, want it refused:\n%s", err, out)
+	}
+}
+
+func TestCheckoutBranchFilesValidatedAfterCheckout(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	// version.properties is only added on the release branch
+	runGit(t, "checkout", "-q", "-b", "release/2.0")
+	writeFixture(t, "version.properties", "versionName=2.0.0\nversionCode=40\n")
+	runGit(t, "add", "version.properties")
+	runGit(t, "commit", "-q", "-m", "Release 2.0")
+	runGit(t, "push", "-q", "origin", "release/2.0")
+	runGit(t, "checkout", "-q", "develop")
+	runGit(t, "branch", "-q", "-D", "release/2.0")
+	exports := fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "version_source": "properties", "checkout_branch": "release/2.0"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if got := exports(); got["BUMP_VERSION_NAME"] != "2.0.1" || got["BUMP_VERSION_CODE"] != "41" {
+		t.Errorf("exported %v, want the bump of release/2.0's version.properties", got)
+	}
+}
+
+func TestCheckoutBranchRejectedInPlanMode(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	if _, err := testConfigs(t, map[string]string{"mode": "plan", "checkout_branch": "develop"}).validate(); err == nil {
+		t.Error("validate() accepted checkout_branch in plan mode")
+	}
+}
+
+func TestGitCommitArgsCoAuthors(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 13}
+	configs := testConfigs(t, map[string]string{
+		"commit_trailers": "Skip-Release: true",
+		"co_authors":      "Jane Doe <jane@example.com>\n\nJohn Roe <john@example.com>\n",
+	})
+
+	want := []string{"commit", "-m", "Bump version to 1.2.4", "-m", "Skip-Release: true\nCo-authored-by: Jane Doe <jane@example.com>\nCo-authored-by: John Roe <john@example.com>"}
+	if args := gitCommitArgs(configs, versions); !equalStrings(args, want) {
+		t.Errorf("gitCommitArgs() = %q, want %q", args, want)
+	}
+
+	newTestRepo(t)
+	if err := gitCommand(append(gitCommitArgs(configs, versions), "--allow-empty")...); err != nil {
+		t.Fatal(err)
+	}
+	if value := runGit(t, "log", "-1", "--format=%(trailers:key=Co-authored-by,valueonly)"); value != "Jane Doe <jane@example.com>\nJohn Roe <john@example.com>" {
+		t.Errorf("Co-authored-by trailers = %q, want both co-authors", value)
+	}
+
+	if _, err := configs.validate(); err != nil {
+		t.Errorf("validate() = %s", err)
+	}
+	for _, coAuthor := range []string{"Jane Doe", "<jane@example.com>", "Jane Doe jane@example.com", "Jane Doe <jane>"} {
+		if _, err := testConfigs(t, map[string]string{"co_authors": coAuthor}).validate(); err == nil {
+			t.Errorf("validate() accepted co-author %q", coAuthor)
+		}
+	}
+}
+
+func TestCommitDate(t *testing.T) {
+	const date = "2024-03-01T12:30:00+01:00"
+	configs := testConfigs(t, map[string]string{"commit_date": date})
+	if envs := gitCommitEnvs(configs); !equalStrings(envs, []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}) {
+		t.Errorf("gitCommitEnvs() = %v, want both dates", envs)
+	}
+	if envs := gitCommitEnvs(testConfigs(t, nil)); len(envs) != 0 {
+		t.Errorf("gitCommitEnvs() without commit_date = %v, want none", envs)
+	}
+
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+	if out, err := runStep(t, map[string]string{"commit_date": date}); err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if dates := runGit(t, "log", "-1", "--format=%aI %cI", "develop"); dates != date+" "+date {
+		t.Errorf("author and committer dates of the bump = %s, want %s", dates, date)
+	}
+
+	for _, invalid := range []string{"2024-03-01", "yesterday", "1709292600"} {
+		if _, err := testConfigs(t, map[string]string{"commit_date": invalid}).validate(); err == nil {
+			t.Errorf("validate() accepted commit_date %q", invalid)
+		}
+	}
+}
+
+func TestDescribeTag(t *testing.T) {
+	newTestRepo(t)
+	if tag, err := describeTag([]string{"v[0-9]*"}, ""); err != nil || tag != "" {
+		t.Errorf("describeTag() without tags = %q, %v, want none", tag, err)
+	}
+
+	runGit(t, "tag", "-a", "-m", "Release", "v1.0.0")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Work")
+	runGit(t, "tag", "v1.1.0")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "More work")
+	if tag, err := describeTag([]string{"v[0-9]*"}, ""); err != nil || tag != "v1.1.0" {
+		t.Errorf("describeTag() = %q, %v, want the nearest v1.1.0", tag, err)
+	}
+
+	// nearer tags of other kinds are skipped
+	runGit(t, "tag", "wear-v2.0.0")
+	runGit(t, "tag", "v14-code")
+	if tag, err := describeTag([]string{"v[0-9]*"}, "v*-code"); err != nil || tag != "v1.1.0" {
+		t.Errorf("describeTag() = %q, %v, want v1.1.0 past the code and other scope tags", tag, err)
+	}
+	if tag, err := describeTag([]string{"wear-v[0-9]*"}, ""); err != nil || tag != "wear-v2.0.0" {
+		t.Errorf("describeTag(wear-v[0-9]*) = %q, %v, want wear-v2.0.0", tag, err)
+	}
+	if tag, err := describeTag([]string{"v*-code"}, "v*-code"); err != nil || tag != "" {
+		t.Errorf("describeTag() with every tag excluded = %q, %v, want none", tag, err)
+	}
+}
+
+func TestVersionFromDescribeSkipsCodeAndScopeTags(t *testing.T) {
+	newTestRepo(t)
+	runGit(t, "tag", "app-1.4.0")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Work")
+	runGit(t, "tag", "app-wear-v3.0.0")
+	runGit(t, "tag", "app-20-build")
+	exports := fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{
+		"mode":                  "export_only",
+		"version_from_describe": "true",
+		"tag_scope":             "app-",
+		"code_tag_template":     "app-{version_code}-build",
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if got := exports(); got["BUMP_VERSION_NAME"] != "1.4.1" {
+		t.Errorf("exported %v, want the bump of app-1.4.0", got)
+	}
+}
+
+func TestVersionFromDescribe(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		tag      string
+		prefix   string
+		fallback string
+		want     string
+		err      string
+	}{
+		{"prefix stripped", "v2.0.0", "v", "file", "2.0.1", ""},
+		{"scoped prefix", "android/3.1.0", "android/", "file", "3.1.1", ""},
+		{"no tags falls back to the file", "", "v", "file", "1.2.4", ""},
+		{"no tags fail", "", "v", "fail", "", "No tag is reachable from HEAD"},
+		{"other prefixes skipped", "v2.0.0", "", "file", "1.2.4", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newTestRepo(t)
+			if test.tag != "" {
+				runGit(t, "tag", test.tag)
+			}
+			exports := fakeEnvman(t)
+
+			out, err := runStep(t, map[string]string{
+				"mode":                  "export_only",
+				"version_from_describe": "true",
+				"describe_prefix":       test.prefix,
+				"describe_fallback":     test.fallback,
+			})
+			if test.err != "" {
+				if err == nil || !strings.Contains(out, test.err) {
+					t.Errorf("step error = %v, want %q:\n%s", err, test.err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("step failed: %s\n%s", err, out)
+			}
+			if name := exports()["BUMP_VERSION_NAME"]; name != test.want {
+				t.Errorf("BUMP_VERSION_NAME = %q, want %s", name, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTag(t *testing.T) {
+	newTestRepo(t)
+
+	if err := verifyTag("1.2.4"); err == nil || err.Error() != "Tag 1.2.4 was not created" {
+		t.Errorf("verifyTag() of a tag that wasn't created = %v", err)
+	}
+
+	runGit(t, "tag", "-a", "-m", "Old release", "1.2.3")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Bump version to 1.2.4")
+	if err := verifyTag("1.2.3"); err == nil || !strings.Contains(err.Error(), "instead of HEAD") {
+		t.Errorf("verifyTag() of a tag on another commit = %v", err)
+	}
+
+	runGit(t, "tag", "-a", "-m", "Release", "1.2.4")
+	if err := verifyTag("1.2.4"); err != nil {
+		t.Errorf("verifyTag() of the annotated tag of HEAD = %s", err)
+	}
+}
+
+func TestVersionsAtRef(t *testing.T) {
+	dir := newTestRepo(t)
+	writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode 20", `"1.2.3"`, `"2.0.0"`).Replace(buildGradleFixture))
+	runGit(t, "commit", "-q", "-am", "Release 2.0.0")
+	// uncommitted changes of the working tree aren't read either
+	writeFixture(t, "app/build.gradle", strings.Replace(buildGradleFixture, "versionCode 12", "versionCode 30", 1))
+	configs := testConfigs(t, nil)
+
+	for _, test := range []struct {
+		ref  string
+		file string
+		want Versions
+	}{
+		{"HEAD~1", "app/build.gradle", Versions{Name: "1.2.3", Code: 12}},
+		{"master", filepath.Join(dir, "app", "build.gradle"), Versions{Name: "1.2.3", Code: 12}},
+		{"HEAD", "./app/build.gradle", Versions{Name: "2.0.0", Code: 20}},
+	} {
+		versions, err := versionsAtRef(configs, test.ref, test.file, test.file)
+		if err != nil {
+			t.Fatalf("versionsAtRef(%s, %s) = %s", test.ref, test.file, err)
+		}
+		if versions.Name != test.want.Name || versions.Code != test.want.Code {
+			t.Errorf("versionsAtRef(%s, %s) = %s (%d), want %s (%d)", test.ref, test.file, versions.Name, versions.Code, test.want.Name, test.want.Code)
+		}
+	}
+
+	if _, err := versionsAtRef(configs, "HEAD~1", "wear/build.gradle", "wear/build.gradle"); err == nil || !strings.Contains(err.Error(), "Failed to read wear/build.gradle at HEAD~1") {
+		t.Errorf("versionsAtRef() of a file missing at the ref error = %v", err)
+	}
+	if versions, err := getVersionsFromFile(configs, "app/build.gradle"); err != nil || versions.Code != 30 {
+		t.Errorf("working tree reader not restored, read %+v, %v", versions, err)
+	}
+}
+
+func TestReadRefMode(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode 20", `"1.2.3"`, `"2.0.0"`).Replace(buildGradleFixture))
+	runGit(t, "commit", "-q", "-am", "Release 2.0.0")
+	exports := fakeEnvman(t)
+	head := runGit(t, "rev-parse", "HEAD")
+
+	out, err := runStep(t, map[string]string{"mode": "read_ref", "read_ref": "HEAD~1"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "./app/build.gradle at HEAD~1: versionName 1.2.3, versionCode 12") {
+		t.Errorf("versions at HEAD~1 not reported:\n%s", out)
+	}
+	if sha := runGit(t, "rev-parse", "HEAD"); sha != head {
+		t.Errorf("HEAD moved from %s to %s", head, sha)
+	}
+	if status := runGit(t, "status", "--porcelain"); status != "" {
+		t.Errorf("working tree changed:\n%s", status)
+	}
+	if got := exports(); len(got) > 0 {
+		t.Errorf("exported %v in read_ref mode", got)
+	}
+}
+
+func TestGitMergeArgsMessage(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 13}
+	configs := testConfigs(t, map[string]string{"merge_no_ff": "true", "merge_message": "Merge release {version_name} ({version_code})"})
+
+	want := []string{"merge", "--no-ff", "--no-edit", "-m", "Merge release 1.2.4 (13)", "develop"}
+	if args := gitMergeArgs(configs, "develop", versions); !equalStrings(args, want) {
+		t.Fatalf("gitMergeArgs() = %q, want %q", args, want)
+	}
+
+	newTestRepo(t)
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "Bump version to 1.2.4")
+	runGit(t, "checkout", "-q", "master")
+	if err := gitCommand(gitMergeArgs(configs, "develop", versions)...); err != nil {
+		t.Fatal(err)
+	}
+	if subject := runGit(t, "log", "-1", "--format=%s"); subject != "Merge release 1.2.4 (13)" {
+		t.Errorf("merge commit subject = %q, want the rendered merge_message", subject)
+	}
+
+	if _, err := testConfigs(t, map[string]string{"merge_message": "  "}).validate(); err == nil || err.Error() != "Invalid merge_message!" {
+		t.Errorf("validate() with a blank merge_message error = %v", err)
+	}
+}
+
+func TestRequireCleanTreeWithNewVersionFile(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+	local := map[string]string{"require_clean_tree": "true", "write_version_file": "VERSION"}
+
+	out, err := runStep(t, local)
+	if err != nil {
+		t.Fatalf("step with a version file yet to be created failed: %s\n%s", err, out)
+	}
+	if content := readFixture(t, "VERSION"); !strings.Contains(content, "1.2.4") {
+		t.Errorf("VERSION = %q, want 1.2.4", content)
+	}
+
+	writeFixture(t, "README.md", "work in progress\n")
+	runGit(t, "add", "README.md")
+	out, err = runStep(t, local)
+	if err == nil || !strings.Contains(out, "Working tree has uncommitted changes") || !strings.Contains(out, "README.md") {
+		t.Errorf("step with a staged README.md error = %v, want it refused:\n%s", err, out)
+	}
+}
+
+func TestRealPathOfMissingFile(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := realPath(filepath.Join(dir, "link", "missing", "version.txt"))
+	if err != nil || path != filepath.Join(dir, "missing", "version.txt") {
+		t.Errorf("realPath() = %s, %v, want it resolved through the link", path, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+// regexpMatch is one candidate value of a version field and the line it's on.
+type regexpMatch struct {
+	Line  int
+	Value string
+}
+
+// findRegexpMatches returns the first capture group of every match in the file.
+func findRegexpMatches(file string, re *regexp.Regexp) ([]regexpMatch, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return []regexpMatch{}, err
+	}
+	content := string(bytes)
+
+	matches := []regexpMatch{}
+	for _, indexes := range re.FindAllStringSubmatchIndex(content, -1) {
+		matches = append(matches, regexpMatch{
+			Line:  strings.Count(content[:indexes[2]], "\n") + 1,
+			Value: content[indexes[2]:indexes[3]],
+		})
+	}
+
+	return matches, nil
+}
+
+func logRegexpMatches(name, file string, re *regexp.Regexp) {
+	log.Detail("%s in %s, pattern %s:", name, file, re)
+	matches, err := findRegexpMatches(file, re)
+	if err != nil {
+		log.Warn("  Failed to read %s: %s", file, err)
+		return
+	}
+	if len(matches) == 0 {
+		log.Detail("  no matches")
+	}
+	for _, match := range matches {
+		log.Detail("  line %d: %s", match.Line, match.Value)
+	}
+}
+
+func inspectVersionName(configs ConfigsModel, nameFile string) {
+	logRegexpMatches("versionName", nameFile, versionNameRegexpFor(configs, nameFile))
+	field, err := locateVersionName(configs, nameFile)
+	if err != nil {
+		log.Warn("  versionName can't be bumped: %s", err)
+		return
+	}
+
+	if field.File != nameFile || field.Regexp.String() != versionNameRegexpFor(configs, nameFile).String() {
+		logRegexpMatches("versionName variable", field.File, field.Regexp)
+	}
+	if value, err := field.read(); err == nil {
+		log.Done("versionName read: %s, a bump overwrites every match", value)
+	}
+}
+
+// logXMLField logs the value of an XML field, only the first matching element is bumped.
+func logXMLField(name string, field versionField, err error) {
+	if err != nil {
+		log.Warn("  %s can't be bumped: %s", name, err)
+		return
+	}
+
+	value, err := field.read()
+	if err != nil {
+		log.Warn("  %s can't be bumped: %s", name, err)
+		return
+	}
+	log.Done("%s read from %s in %s: %s", name, field.XML, field.File, value)
+}
+
+// inspectVersionFiles logs every candidate the versionName and versionCode patterns match and
+// which one a bump would use, without changing anything.
+func inspectVersionFiles(configs ConfigsModel, codeFile, nameFile string) {
+	if isXMLFile(nameFile) {
+		field, err := locateVersionName(configs, nameFile)
+		logXMLField("versionName", field, err)
+	} else {
+		inspectVersionName(configs, nameFile)
+	}
+
+	if isXMLFile(codeFile) {
+		field, err := locateVersionCode(configs, codeFile)
+		logXMLField("versionCode", field, err)
+	} else {
+		logRegexpMatches("versionCode", codeFile, versionCodeRegexpFor(configs, codeFile))
+		if field, err := locateVersionCode(configs, codeFile); err != nil {
+			log.Warn("  versionCode can't be bumped: %s", err)
+		} else if value, err := field.read(); err == nil {
+			log.Done("versionCode read: %s, a bump overwrites every match", value)
+		}
+	}
+
+	if configs.ExtraCodeField != "" {
+		logRegexpMatches(configs.ExtraCodeField, codeFile, extraCodeRegexp(codeFile, configs.ExtraCodeField))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindRegexpMatches(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", strings.Replace(buildGradleFixture, "    }\n", "    }\n    flavors {\n        versionCode 20\n    }\n", 1))
+
+	matches, err := findRegexpMatches("app/build.gradle", versionCodeRegexp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []regexpMatch{{Line: 3, Value: "12"}, {Line: 7, Value: "20"}}
+	if len(matches) != len(want) || matches[0] != want[0] || matches[1] != want[1] {
+		t.Errorf("findRegexpMatches() = %v, want %v", matches, want)
+	}
+}
+
+func TestInspectReportsMatches(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runStep(t, map[string]string{"mode": "inspect"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	for _, line := range []string{"line 3: 12", "line 4: 1.2.3"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("%q not reported:\n%s", line, out)
+		}
+	}
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle changed to:\n%s", content)
+	}
+}
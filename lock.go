@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+const lockRetryInterval = 100 * time.Millisecond
+
+// fileLock is an exclusive lock on a file, held by the `<file>.lock` file existing. The lock file holds
+// the PID of the run holding it, so a lock left behind by a killed run can be broken.
+type fileLock struct {
+	path string
+}
+
+func lockFile(file string, timeout time.Duration) (*fileLock, error) {
+	path := file + ".lock"
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(lock, "%d\n", os.Getpid())
+			lock.Close()
+			return &fileLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if isStaleLock(path, timeout) {
+			log.Warn("Removing %s left behind by a bump that is no longer running", path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out after %s waiting for %s, remove it if no other bump is running", timeout, path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// isStaleLock tells whether the process of the lock's PID is gone, or, for a lock without a PID, e.g. of
+// a run killed before writing it, whether the lock is older than the timeout.
+func isStaleLock(path string, timeout time.Duration) bool {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bytes)))
+	if err != nil || pid <= 0 {
+		info, err := os.Stat(path)
+		return err == nil && time.Since(info.ModTime()) > timeout
+	}
+
+	// signal 0 only checks that the process exists
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+func (lock *fileLock) unlock() error {
+	return os.Remove(lock.path)
+}
+
+// lockFiles locks every file in a stable order, so concurrent runs can't deadlock each other.
+func lockFiles(files []string, timeout time.Duration) ([]*fileLock, error) {
+	sorted := append([]string{}, files...)
+	sort.Strings(sorted)
+
+	locks := []*fileLock{}
+	for i, file := range sorted {
+		if i > 0 && file == sorted[i-1] {
+			continue
+		}
+		lock, err := lockFile(file, timeout)
+		if err != nil {
+			unlockFiles(locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+func unlockFiles(locks []*fileLock) {
+	for _, lock := range locks {
+		if err := lock.unlock(); err != nil {
+			log.Warn("Failed to remove lock %s: %s", lock.path, err)
+		}
+	}
+}
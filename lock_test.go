@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockFileTimesOut(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "build.gradle")
+
+	lock, err := lockFile(file, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lockFile(file, 0); err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Fatalf("lockFile() of a locked file error = %v, want a timeout", err)
+	}
+
+	if err := lock.unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = lockFile(file, 0)
+	if err != nil {
+		t.Fatalf("lockFile() after unlock = %s", err)
+	}
+	lock.unlock()
+}
+
+func TestLockFileBreaksStaleLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "build.gradle")
+
+	// the PID of a run that has exited
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	writeFixture(t, file+".lock", fmt.Sprintf("%d\n", exited.Process.Pid))
+
+	lock, err := lockFile(file, 0)
+	if err != nil {
+		t.Fatalf("lockFile() with a stale lock = %s", err)
+	}
+	if content := readFixture(t, file+".lock"); content != fmt.Sprintf("%d\n", os.Getpid()) {
+		t.Errorf("lock = %q, want the PID of the test", content)
+	}
+	lock.unlock()
+
+	// a lock without a PID is only stale once it's older than the timeout, a lock of a running process never
+	writeFixture(t, file+".lock", "")
+	if isStaleLock(file+".lock", time.Minute) {
+		t.Error("isStaleLock() of a new lock without a PID = true")
+	}
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(file+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !isStaleLock(file+".lock", time.Minute) {
+		t.Error("isStaleLock() of an old lock without a PID = false")
+	}
+	writeFixture(t, file+".lock", fmt.Sprintf("%d\n", os.Getpid()))
+	if err := os.Chtimes(file+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if isStaleLock(file+".lock", time.Minute) {
+		t.Error("isStaleLock() of the test's own lock = true")
+	}
+}
+
+func TestLockFilesReleasesOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.gradle"), filepath.Join(dir, "b.gradle")
+
+	held, err := lockFile(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockFiles([]string{b, a, a}, 0); err == nil {
+		t.Fatal("lockFiles() with a held lock succeeded")
+	}
+	if _, err := os.Stat(a + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock of %s left behind after the failure", a)
+	}
+	held.unlock()
+
+	// a file listed twice is locked once
+	locks, err := lockFiles([]string{b, a, a}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 2 {
+		t.Errorf("lockFiles() = %d locks, want 2", len(locks))
+	}
+	unlockFiles(locks)
+}
+
+func TestConcurrentBumpsAreNotLost(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	configs := testConfigs(t, map[string]string{"bump_type": "none", "lock_timeout": "10"})
+
+	const bumps = 8
+	errs := make(chan error, bumps)
+	var wg sync.WaitGroup
+	for i := 0; i < bumps; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				// a bump racing another one fails and is run again, like a retried step
+				versions, err := getVersionsFromFile(configs, "app/build.gradle")
+				if err == nil {
+					err = setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", versions, Versions{Name: versions.Name, Code: versions.Code + 1})
+					if err == nil {
+						errs <- nil
+						return
+					}
+				}
+				if time.Now().After(deadline) {
+					errs <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := getVersionsFromFile(configs, "app/build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Code != 12+bumps {
+		t.Errorf("versionCode = %d after %d bumps of 12, a bump was lost", versions.Code, bumps)
+	}
+}
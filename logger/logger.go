@@ -1,44 +1,112 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 )
 
+var failHooks = []func(){}
+
+// OnFail registers a hook run by Exit, e.g. to undo temporary changes.
+func OnFail(hook func()) {
+	failHooks = append(failHooks, hook)
+}
+
+// Exit runs the fail hooks in reverse order and exits.
+func Exit(code int) {
+	for i := len(failHooks) - 1; i >= 0; i-- {
+		failHooks[i]()
+	}
+	os.Exit(code)
+}
+
+var jsonFormat = false
+
+// output receives the log lines, commandOutput the output of commands run while json format is on.
+var output, commandOutput io.Writer = os.Stdout, os.Stderr
+
+// SetFormat switches between the colored `text` lines and `json`, one object with level and message per line.
+func SetFormat(format string) {
+	jsonFormat = format == "json"
+}
+
+type entry struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func write(level, text, message string) {
+	if jsonFormat {
+		bytes, err := json.Marshal(entry{Level: level, Message: message})
+		if err != nil {
+			// a string always marshals, keep the line parseable regardless
+			bytes = []byte(`{"level":"error","message":"unprintable log message"}`)
+		}
+		fmt.Fprintln(output, string(bytes))
+		return
+	}
+
+	fmt.Fprintln(output, text)
+}
+
+// Writer returns where the output of git and other commands the step runs belongs: next to the log lines
+// in text format, on stderr in json format so that stdout stays one JSON object per line.
+func Writer() io.Writer {
+	if jsonFormat {
+		return commandOutput
+	}
+	return output
+}
+
 // Fail ...
 func Fail(format string, v ...interface{}) {
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("\x1b[31;1m%s\x1b[0m\n", errorMsg)
-	os.Exit(1)
+	write("fail", fmt.Sprintf("\x1b[31;1m%s\x1b[0m", errorMsg), errorMsg)
+	Exit(1)
 }
 
 // Error ...
 func Error(format string, v ...interface{}) {
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("\x1b[31;1m%s\x1b[0m\n", errorMsg)
+	write("error", fmt.Sprintf("\x1b[31;1m%s\x1b[0m", errorMsg), errorMsg)
 }
 
 // Warn ...
 func Warn(format string, v ...interface{}) {
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("\x1b[33;1m%s\x1b[0m\n", errorMsg)
+	write("warn", fmt.Sprintf("\x1b[33;1m%s\x1b[0m", errorMsg), errorMsg)
 }
 
 // Info ...
 func Info(format string, v ...interface{}) {
-	fmt.Println()
+	Newline()
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("\x1b[34;1m%s\x1b[0m\n", errorMsg)
+	write("info", fmt.Sprintf("\x1b[34;1m%s\x1b[0m", errorMsg), errorMsg)
 }
 
 // Detail ...
 func Detail(format string, v ...interface{}) {
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("  %s\n", errorMsg)
+	write("detail", fmt.Sprintf("  %s", errorMsg), errorMsg)
 }
 
 // Done ...
 func Done(format string, v ...interface{}) {
 	errorMsg := fmt.Sprintf(format, v...)
-	fmt.Printf("  \x1b[32;1m%s\x1b[0m\n", errorMsg)
+	write("done", fmt.Sprintf("  \x1b[32;1m%s\x1b[0m", errorMsg), errorMsg)
+}
+
+// Plain prints the message without color or indentation.
+func Plain(format string, v ...interface{}) {
+	errorMsg := fmt.Sprintf(format, v...)
+	write("plain", errorMsg, errorMsg)
+}
+
+// Newline separates sections of the text format, it prints nothing in json format.
+func Newline() {
+	if !jsonFormat {
+		fmt.Fprintln(output)
+	}
 }
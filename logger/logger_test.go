@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func captureOutput(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	previous := output
+	output = &buffer
+	SetFormat(format)
+	t.Cleanup(func() {
+		output = previous
+		SetFormat("text")
+	})
+	return &buffer
+}
+
+func TestJSONFormat(t *testing.T) {
+	buffer := captureOutput(t, "json")
+
+	Info("Bump %s", "1.2.3")
+	Detail("multi\nline")
+	Warn(`quoted "value"`)
+	Newline()
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	want := []entry{{"info", "Bump 1.2.3"}, {"detail", "multi\nline"}, {"warn", `quoted "value"`}}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), buffer)
+	}
+	for i, line := range lines {
+		var got entry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %q is not JSON: %s", line, err)
+		}
+		if got != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buffer := captureOutput(t, "text")
+	if Writer() != buffer {
+		t.Errorf("Writer() in text format is not the log output")
+	}
+
+	SetFormat("json")
+	if Writer() != os.Stderr {
+		t.Errorf("Writer() in json format is not stderr")
+	}
+}
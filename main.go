@@ -3,247 +3,2810 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/errorutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/coreos/go-semver/semver"
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+type ConfigsModel struct {
+	ConfigFile string
+	LogFormat  string
+
+	BumpType            string
+	BumpTrailer         string
+	BumpTrailerDefault  string
+	CodeIncrement       string
+	MinVersionCode      string
+	Mode                string
+	ReadRef             string
+	PlanOutputPath      string
+	VersionOutputFile   string
+	VersionJSONFile     string
+	ExportEnvman        string
+	GitHubOutput        string
+	VersionConsumer     string
+	GradleFilePath      string
+	FileGlob            string
+	MissingFileBehavior string
+	IncludePathPattern  string
+	ExcludePathPattern  string
+	VersionSource       string
+	CodeFile            string
+	NameFile            string
+	RequireVersionName  string
+	ConditionalBranch   string
+	ExtraCodeField      string
+	SharedVersionFiles  string
+	WriteVersionFile    string
+	VersionFileCode     string
+	ForceResync         string
+	Module              string
+	ModuleBumpTypes     string
+
+	BuildSrcFile         string
+	BuildSrcNameConstant string
+	BuildSrcCodeConstant string
+	PubspecFile          string
+	PubspecSyncGradle    string
+	PropertiesFile       string
+	PropertiesNameKey    string
+	PropertiesCodeKey    string
+	XMLFile              string
+	XMLNameElement       string
+	XMLNameAttribute     string
+	XMLCodeElement       string
+	XMLCodeAttribute     string
+	MakeWritable         string
+	LockTimeout          string
+
+	BuildMetadataEnv     string
+	BuildMetadataPrefix  string
+	StripMetadataOnWrite string
+
+	NameFromBuild  string
+	NameBase       string
+	BuildNumberEnv string
+
+	Environment         string
+	EnvironmentSuffixes string
+
+	PreserveComponentCount string
+	PreserveLeadingZeros   string
+	FlavorOffsets          string
+	VersionFormat          string
+
+	SetMajor string
+	SetMinor string
+	SetPatch string
+
+	SkipIfAtTarget     string
+	RequireCoupledBump string
+
+	GitAuthorName   string
+	GitAuthorEmail  string
+	GitConfigScope  string
+	RestoreConfig   string
+	Signoff         string
+	Amend           string
+	ForceWithLease  string
+	SetUpstream     string
+	PushTags        string
+	TagPushMode     string
+	CodeTagTemplate string
+	TagScope        string
+	OnExistingTag   string
+	TagPushDelay    string
+	ReleaseRetries  string
+	Unshallow       string
+
+	DoCommit        string
+	DoPushBranch    string
+	DoMerge         string
+	DoTag           string
+	PushBranch      string
+	CheckoutBranch  string
+	AllowedBranches string
+	GitRemotes      string
+	MergeBranch     string
+	MergeNoFF       string
+	MergeMessage    string
+
+	CommitMessage  string
+	CommitType     string
+	CommitTrailers string
+	CoAuthors      string
+	CommitDate     string
+	CommitBody     string
+
+	PostBumpMergeBack string
+	MergeBackBranch   string
+
+	SkipIfLastCommitIsBump string
+	RequireCleanTree       string
+	CheckTagOrder          string
+	VersionFromTag         string
+	VersionFromDescribe    string
+	DescribePrefix         string
+	DescribeFallback       string
+	TagPattern             string
+	RemoteVersionURL       string
+	ListMatches            string
+	EnvmanFailure          string
+	ExportUnchangedName    string
+	ExportDiffPath         string
+}
+
+type Versions struct {
+	Code int    `json:"code"`
+	Name string `json:"name"`
+	// ExtraCode is the numeric extra_code_field, e.g. buildNumber, bumped alongside versionCode.
+	ExtraCode int `json:"extra_code,omitempty"`
+	// FlavorCodes holds the versionCode of each product flavor with a configured offset.
+	FlavorCodes map[string]int `json:"flavor_codes,omitempty"`
+	// codeWidth is the number of digits versionCode is written with in the file, e.g. 3 for `007`.
+	codeWidth int
+}
+
+type Summary struct {
+	File          string   `json:"file"`
+	Old           Versions `json:"old"`
+	New           Versions `json:"new"`
+	Tag           string   `json:"tag"`
+	CommitMessage string   `json:"commit_message"`
+}
+
+// createConfigsModel reads the inputs from the environment and the optional config_file,
+// a non-empty environment variable overrides the file.
+func createConfigsModel() (ConfigsModel, error) {
+	inputs := &configInputs{file: map[string]string{}, known: map[string]bool{}}
+	configFile := os.Getenv("config_file")
+	if configFile != "" {
+		values, err := loadConfigFile(configFile)
+		if err != nil {
+			return ConfigsModel{}, err
+		}
+		inputs.file = values
+	}
+
+	configs := ConfigsModel{
+		ConfigFile: configFile,
+		LogFormat:  inputs.get("log_format"),
+
+		BumpType:            inputs.get("bump_type"),
+		BumpTrailer:         inputs.get("bump_trailer"),
+		BumpTrailerDefault:  inputs.get("bump_trailer_default"),
+		CodeIncrement:       inputs.get("code_increment"),
+		MinVersionCode:      inputs.get("min_version_code"),
+		Mode:                inputs.get("mode"),
+		ReadRef:             inputs.get("read_ref"),
+		PlanOutputPath:      inputs.get("plan_output_path"),
+		VersionOutputFile:   inputs.get("version_output_file"),
+		VersionJSONFile:     inputs.get("version_json_file"),
+		ExportEnvman:        inputs.get("export_envman"),
+		GitHubOutput:        inputs.get("github_output"),
+		VersionConsumer:     inputs.get("version_consumer_command"),
+		GradleFilePath:      inputs.get("gradle_file_path"),
+		FileGlob:            inputs.get("file_glob"),
+		MissingFileBehavior: inputs.get("missing_file_behavior"),
+		IncludePathPattern:  inputs.get("include_path_pattern"),
+		ExcludePathPattern:  inputs.get("exclude_path_pattern"),
+		VersionSource:       inputs.get("version_source"),
+		CodeFile:            inputs.get("code_file"),
+		NameFile:            inputs.get("name_file"),
+		RequireVersionName:  inputs.get("require_version_name"),
+		ConditionalBranch:   inputs.get("conditional_branch"),
+		ExtraCodeField:      inputs.get("extra_code_field"),
+		SharedVersionFiles:  inputs.get("shared_version_files"),
+		WriteVersionFile:    inputs.get("write_version_file"),
+		VersionFileCode:     inputs.get("version_file_code"),
+		ForceResync:         inputs.get("force_resync"),
+		Module:              inputs.get("module"),
+		ModuleBumpTypes:     inputs.get("module_bump_types"),
+
+		BuildSrcFile:         inputs.get("buildsrc_file"),
+		BuildSrcNameConstant: inputs.get("buildsrc_name_constant"),
+		BuildSrcCodeConstant: inputs.get("buildsrc_code_constant"),
+		PubspecFile:          inputs.get("pubspec_file"),
+		PubspecSyncGradle:    inputs.get("pubspec_sync_gradle_file"),
+		PropertiesFile:       inputs.get("properties_file"),
+		PropertiesNameKey:    inputs.get("properties_name_key"),
+		PropertiesCodeKey:    inputs.get("properties_code_key"),
+		XMLFile:              inputs.get("xml_file"),
+		XMLNameElement:       inputs.get("xml_name_element"),
+		XMLNameAttribute:     inputs.get("xml_name_attribute"),
+		XMLCodeElement:       inputs.get("xml_code_element"),
+		XMLCodeAttribute:     inputs.get("xml_code_attribute"),
+		MakeWritable:         inputs.get("make_writable"),
+		LockTimeout:          inputs.get("lock_timeout"),
+
+		BuildMetadataEnv:     inputs.get("build_metadata_env"),
+		BuildMetadataPrefix:  inputs.get("build_metadata_prefix"),
+		StripMetadataOnWrite: inputs.get("strip_build_metadata_on_write"),
+
+		NameFromBuild:  inputs.get("name_from_build"),
+		NameBase:       inputs.get("name_base"),
+		BuildNumberEnv: inputs.get("build_number_env"),
+
+		Environment:         inputs.get("environment"),
+		EnvironmentSuffixes: inputs.get("environment_suffixes"),
+
+		PreserveComponentCount: inputs.get("preserve_component_count"),
+		PreserveLeadingZeros:   inputs.get("preserve_leading_zeros"),
+		FlavorOffsets:          inputs.get("flavor_offsets"),
+		VersionFormat:          inputs.get("version_format"),
+
+		SetMajor: inputs.get("set_major"),
+		SetMinor: inputs.get("set_minor"),
+		SetPatch: inputs.get("set_patch"),
+
+		SkipIfAtTarget:     inputs.get("skip_if_at_target"),
+		RequireCoupledBump: inputs.get("require_coupled_bump"),
+
+		GitAuthorName:   inputs.get("git_author_name"),
+		GitAuthorEmail:  inputs.get("git_author_email"),
+		GitConfigScope:  inputs.get("git_config_scope"),
+		RestoreConfig:   inputs.get("restore_git_config"),
+		Signoff:         inputs.get("signoff"),
+		Amend:           inputs.get("amend"),
+		ForceWithLease:  inputs.get("force_with_lease"),
+		SetUpstream:     inputs.get("set_upstream"),
+		PushTags:        inputs.get("push_tags"),
+		TagPushMode:     inputs.get("tag_push_mode"),
+		CodeTagTemplate: inputs.get("code_tag_template"),
+		TagScope:        inputs.get("tag_scope"),
+		OnExistingTag:   inputs.get("on_existing_tag"),
+		TagPushDelay:    inputs.get("tag_push_delay"),
+		ReleaseRetries:  inputs.get("release_retries"),
+		Unshallow:       inputs.get("unshallow"),
+
+		DoCommit:        inputs.get("do_commit"),
+		DoPushBranch:    inputs.get("do_push_branch"),
+		DoMerge:         inputs.get("do_merge"),
+		DoTag:           inputs.get("do_tag"),
+		PushBranch:      inputs.get("push_branch"),
+		CheckoutBranch:  inputs.get("checkout_branch"),
+		AllowedBranches: inputs.get("allowed_branches"),
+		GitRemotes:      inputs.get("git_remotes"),
+		MergeBranch:     inputs.get("merge_branch"),
+		MergeNoFF:       inputs.get("merge_no_ff"),
+		MergeMessage:    inputs.get("merge_message"),
+
+		CommitMessage:  inputs.get("commit_message"),
+		CommitType:     inputs.get("commit_type"),
+		CommitTrailers: inputs.get("commit_trailers"),
+		CoAuthors:      inputs.get("co_authors"),
+		CommitDate:     inputs.get("commit_date"),
+		CommitBody:     inputs.get("commit_body"),
+
+		PostBumpMergeBack: inputs.get("post_bump_merge_back"),
+		MergeBackBranch:   inputs.get("merge_back_branch"),
+
+		SkipIfLastCommitIsBump: inputs.get("skip_if_last_commit_is_bump"),
+		RequireCleanTree:       inputs.get("require_clean_tree"),
+		CheckTagOrder:          inputs.get("check_tag_order"),
+		VersionFromTag:         inputs.get("version_from_tag"),
+		VersionFromDescribe:    inputs.get("version_from_describe"),
+		DescribePrefix:         inputs.get("describe_prefix"),
+		DescribeFallback:       inputs.get("describe_fallback"),
+		TagPattern:             inputs.get("tag_pattern"),
+		RemoteVersionURL:       inputs.get("remote_version_url"),
+		ListMatches:            inputs.get("list_matches"),
+		EnvmanFailure:          inputs.get("envman_failure"),
+		ExportUnchangedName:    inputs.get("export_unchanged_version_name"),
+		ExportDiffPath:         inputs.get("export_diff_path"),
+	}
+
+	if unknown := inputs.unknownKeys(); len(unknown) > 0 {
+		return ConfigsModel{}, fmt.Errorf("Unknown inputs in %s: %s", configFile, strings.Join(unknown, ", "))
+	}
+
+	return configs, nil
+}
+
+func (configs ConfigsModel) print() {
+	log.Info("Configs:")
+	log.Detail("- ConfigFile: %s", configs.ConfigFile)
+	log.Detail("- LogFormat: %s", configs.LogFormat)
+	log.Detail("- BumpType: %s", configs.BumpType)
+	log.Detail("- BumpTrailer: %s", configs.BumpTrailer)
+	log.Detail("- BumpTrailerDefault: %s", configs.BumpTrailerDefault)
+	log.Detail("- CodeIncrement: %s", configs.CodeIncrement)
+	log.Detail("- MinVersionCode: %s", configs.MinVersionCode)
+	log.Detail("- Mode: %s", configs.Mode)
+	log.Detail("- ReadRef: %s", configs.ReadRef)
+	log.Detail("- PlanOutputPath: %s", configs.PlanOutputPath)
+	log.Detail("- VersionOutputFile: %s", configs.VersionOutputFile)
+	log.Detail("- VersionJSONFile: %s", configs.VersionJSONFile)
+	log.Detail("- ExportEnvman: %s", configs.ExportEnvman)
+	log.Detail("- GitHubOutput: %s", configs.GitHubOutput)
+	log.Detail("- VersionConsumer: %s", configs.VersionConsumer)
+	log.Detail("- GradleFilePath: %s", configs.GradleFilePath)
+	log.Detail("- FileGlob: %s", configs.FileGlob)
+	log.Detail("- MissingFileBehavior: %s", configs.MissingFileBehavior)
+	log.Detail("- IncludePathPattern: %s", configs.IncludePathPattern)
+	log.Detail("- ExcludePathPattern: %s", configs.ExcludePathPattern)
+	log.Detail("- VersionSource: %s", configs.VersionSource)
+	log.Detail("- BuildSrcFile: %s", configs.BuildSrcFile)
+	log.Detail("- BuildSrcNameConstant: %s", configs.BuildSrcNameConstant)
+	log.Detail("- BuildSrcCodeConstant: %s", configs.BuildSrcCodeConstant)
+	log.Detail("- PubspecFile: %s", configs.PubspecFile)
+	log.Detail("- PubspecSyncGradle: %s", configs.PubspecSyncGradle)
+	log.Detail("- PropertiesFile: %s", configs.PropertiesFile)
+	log.Detail("- PropertiesNameKey: %s", configs.PropertiesNameKey)
+	log.Detail("- PropertiesCodeKey: %s", configs.PropertiesCodeKey)
+	log.Detail("- XMLFile: %s", configs.XMLFile)
+	log.Detail("- XMLNameElement: %s", configs.XMLNameElement)
+	log.Detail("- XMLNameAttribute: %s", configs.XMLNameAttribute)
+	log.Detail("- XMLCodeElement: %s", configs.XMLCodeElement)
+	log.Detail("- XMLCodeAttribute: %s", configs.XMLCodeAttribute)
+	log.Detail("- CodeFile: %s", configs.CodeFile)
+	log.Detail("- NameFile: %s", configs.NameFile)
+	log.Detail("- RequireVersionName: %s", configs.RequireVersionName)
+	log.Detail("- ConditionalBranch: %s", configs.ConditionalBranch)
+	log.Detail("- ExtraCodeField: %s", configs.ExtraCodeField)
+	log.Detail("- SharedVersionFiles: %s", configs.SharedVersionFiles)
+	log.Detail("- WriteVersionFile: %s", configs.WriteVersionFile)
+	log.Detail("- VersionFileCode: %s", configs.VersionFileCode)
+	log.Detail("- ForceResync: %s", configs.ForceResync)
+	log.Detail("- Module: %s", configs.Module)
+	log.Detail("- ModuleBumpTypes: %s", configs.ModuleBumpTypes)
+	log.Detail("- MakeWritable: %s", configs.MakeWritable)
+	log.Detail("- LockTimeout: %s", configs.LockTimeout)
+	log.Detail("- BuildMetadataEnv: %s", configs.BuildMetadataEnv)
+	log.Detail("- BuildMetadataPrefix: %s", configs.BuildMetadataPrefix)
+	log.Detail("- StripMetadataOnWrite: %s", configs.StripMetadataOnWrite)
+	log.Detail("- NameFromBuild: %s", configs.NameFromBuild)
+	log.Detail("- NameBase: %s", configs.NameBase)
+	log.Detail("- BuildNumberEnv: %s", configs.BuildNumberEnv)
+	log.Detail("- Environment: %s", configs.Environment)
+	log.Detail("- EnvironmentSuffixes: %s", configs.EnvironmentSuffixes)
+	log.Detail("- PreserveComponentCount: %s", configs.PreserveComponentCount)
+	log.Detail("- PreserveLeadingZeros: %s", configs.PreserveLeadingZeros)
+	log.Detail("- FlavorOffsets: %s", configs.FlavorOffsets)
+	log.Detail("- VersionFormat: %s", configs.VersionFormat)
+	log.Detail("- SetMajor: %s", configs.SetMajor)
+	log.Detail("- SetMinor: %s", configs.SetMinor)
+	log.Detail("- SetPatch: %s", configs.SetPatch)
+	log.Detail("- SkipIfAtTarget: %s", configs.SkipIfAtTarget)
+	log.Detail("- RequireCoupledBump: %s", configs.RequireCoupledBump)
+	log.Detail("- GitAuthorName: %s", configs.GitAuthorName)
+	log.Detail("- GitAuthorEmail: %s", configs.GitAuthorEmail)
+	log.Detail("- GitConfigScope: %s", configs.GitConfigScope)
+	log.Detail("- RestoreConfig: %s", configs.RestoreConfig)
+	log.Detail("- Signoff: %s", configs.Signoff)
+	log.Detail("- Amend: %s", configs.Amend)
+	log.Detail("- ForceWithLease: %s", configs.ForceWithLease)
+	log.Detail("- SetUpstream: %s", configs.SetUpstream)
+	log.Detail("- PushTags: %s", configs.PushTags)
+	log.Detail("- TagPushMode: %s", configs.TagPushMode)
+	log.Detail("- CodeTagTemplate: %s", configs.CodeTagTemplate)
+	log.Detail("- TagScope: %s", configs.TagScope)
+	log.Detail("- OnExistingTag: %s", configs.OnExistingTag)
+	log.Detail("- TagPushDelay: %s", configs.TagPushDelay)
+	log.Detail("- ReleaseRetries: %s", configs.ReleaseRetries)
+	log.Detail("- Unshallow: %s", configs.Unshallow)
+	log.Detail("- DoCommit: %s", configs.DoCommit)
+	log.Detail("- DoPushBranch: %s", configs.DoPushBranch)
+	log.Detail("- DoMerge: %s", configs.DoMerge)
+	log.Detail("- DoTag: %s", configs.DoTag)
+	log.Detail("- PushBranch: %s", configs.PushBranch)
+	log.Detail("- CheckoutBranch: %s", configs.CheckoutBranch)
+	log.Detail("- AllowedBranches: %s", configs.AllowedBranches)
+	log.Detail("- GitRemotes: %s", configs.GitRemotes)
+	log.Detail("- MergeBranch: %s", configs.MergeBranch)
+	log.Detail("- MergeNoFF: %s", configs.MergeNoFF)
+	log.Detail("- MergeMessage: %s", configs.MergeMessage)
+	log.Detail("- CommitMessage: %s", configs.CommitMessage)
+	log.Detail("- CommitType: %s", configs.CommitType)
+	log.Detail("- CommitBody: %s", configs.CommitBody)
+	log.Detail("- CommitTrailers: %s", strings.Join(nonEmptyLines(configs.CommitTrailers), ", "))
+	log.Detail("- CoAuthors: %s", strings.Join(nonEmptyLines(configs.CoAuthors), ", "))
+	log.Detail("- CommitDate: %s", configs.CommitDate)
+	log.Detail("- PostBumpMergeBack: %s", configs.PostBumpMergeBack)
+	log.Detail("- MergeBackBranch: %s", configs.MergeBackBranch)
+	log.Detail("- SkipIfLastCommitIsBump: %s", configs.SkipIfLastCommitIsBump)
+	log.Detail("- RequireCleanTree: %s", configs.RequireCleanTree)
+	log.Detail("- CheckTagOrder: %s", configs.CheckTagOrder)
+	log.Detail("- VersionFromTag: %s", configs.VersionFromTag)
+	log.Detail("- VersionFromDescribe: %s", configs.VersionFromDescribe)
+	log.Detail("- DescribePrefix: %s", configs.DescribePrefix)
+	log.Detail("- DescribeFallback: %s", configs.DescribeFallback)
+	log.Detail("- TagPattern: %s", configs.TagPattern)
+	log.Detail("- RemoteVersionURL: %s", configs.RemoteVersionURL)
+	log.Detail("- ListMatches: %s", configs.ListMatches)
+	log.Detail("- EnvmanFailure: %s", configs.EnvmanFailure)
+	log.Detail("- ExportUnchangedName: %s", configs.ExportUnchangedName)
+	log.Detail("- ExportDiffPath: %s", configs.ExportDiffPath)
+}
+
+// bumpTypes are the bump types of the version parsers, bump_type also accepts from-commit.
+var bumpTypes = []string{"major", "minor", "patch", "none", "prerelease-increment", "metadata-increment", "finalize", "beta", "release"}
+
+var propertiesKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+var extraCodeFieldRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var bumpTrailerRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func (configs ConfigsModel) validate() (string, error) {
+	if !sliceutil.IsStringInSlice(configs.LogFormat, []string{"text", "json"}) {
+		return "Log format must be text or json.", errors.New("Invalid log_format!")
+	}
+
+	if allowed := append(append([]string{}, bumpTypes...), "from-commit"); !sliceutil.IsStringInSlice(configs.BumpType, allowed) {
+		return fmt.Sprintf("Bump type must be one of: %s.", strings.Join(allowed, ", ")), ErrInvalidBumpType
+	}
+
+	if configs.BumpType == "from-commit" {
+		if !bumpTrailerRegexp.MatchString(configs.BumpTrailer) {
+			return "Bump trailer must be a trailer key, e.g. Bump.", errors.New("Invalid bump_trailer!")
+		}
+		if !sliceutil.IsStringInSlice(configs.BumpTrailerDefault, bumpTypes) {
+			return fmt.Sprintf("Bump trailer default must be one of: %s.", strings.Join(bumpTypes, ", ")), fmt.Errorf("%w (bump_trailer_default)", ErrInvalidBumpType)
+		}
+	}
+
+	codeIncrement, err := strconv.Atoi(configs.CodeIncrement)
+	if err != nil || codeIncrement < 0 {
+		return "Code increment must be a non-negative integer, e.g. 1.", errors.New("Invalid code_increment!")
+	}
+
+	if configs.MinVersionCode != "" {
+		if floor, err := strconv.ParseInt(configs.MinVersionCode, 10, 32); err != nil || floor <= 0 {
+			return "Min version code must be empty or a positive integer up to 2147483647.", errors.New("Invalid min_version_code!")
+		}
+	}
+
+	if overrides, err := configs.componentOverrides(); err != nil {
+		return "set_major, set_minor and set_patch must be empty or non-negative integers.", err
+	} else if configs.BumpType == "none" && codeIncrement == 0 && len(overrides) == 0 {
+		return "With bump type none, code increment 0 and no set_major, set_minor or set_patch neither versionName nor versionCode would change. Set a bump type, a positive code increment or a component.", errors.New("Nothing to bump!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.SkipIfAtTarget, []string{"true", "false"}) {
+		return "Skip if at target must be true or false.", errors.New("Invalid skip_if_at_target!")
+	}
+	if configs.SkipIfAtTarget == "true" && (configs.SetMajor == "" || configs.SetMinor == "" || configs.SetPatch == "") {
+		return "Skip if at target needs the explicit version set with all of set_major, set_minor and set_patch.", errors.New("Missing explicit version!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ConditionalBranch, []string{"", "true", "false"}) {
+		return "Conditional branch must be empty, true or false.", errors.New("Invalid conditional_branch!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.RequireCoupledBump, []string{"true", "false"}) {
+		return "Require coupled bump must be true or false.", errors.New("Invalid require_coupled_bump!")
+	}
+	if configs.RequireCoupledBump == "true" {
+		overrides, _ := configs.componentOverrides()
+		nameMoves := configs.BumpType != "none" || len(overrides) > 0
+		if nameMoves && codeIncrement == 0 {
+			return "With require_coupled_bump, a versionName bump needs a positive code increment.", errors.New("Decoupled bump!")
+		}
+		if !nameMoves && codeIncrement > 0 {
+			return "With require_coupled_bump, bump type none only bumps versionCode, set a bump type or code increment 0.", errors.New("Decoupled bump!")
+		}
+	}
+
+	modes := []string{"bump", "plan", "export_only", "doctor", "fail_if_bump_needed", "inspect", "read_ref"}
+	if !sliceutil.IsStringInSlice(configs.Mode, modes) {
+		return fmt.Sprintf("Mode must be one of: %s.", strings.Join(modes, ", ")), errors.New("Invalid mode!")
+	}
+	if configs.Mode == "read_ref" && strings.TrimSpace(configs.ReadRef) == "" {
+		return "Mode read_ref needs the ref to read the versions at, e.g. v1.2.3 or HEAD~5.", errors.New("Missing read_ref!")
+	}
+	if configs.Mode == "fail_if_bump_needed" && strings.TrimSpace(configs.ReadRef) == "" && configs.RemoteVersionURL == "" && configs.SkipIfAtTarget != "true" {
+		// without a reference the file is compared with its own bump, which always differs
+		return "Mode fail_if_bump_needed compares the versions with a reference, set read_ref (e.g. origin/main), remote_version_url or skip_if_at_target.", errors.New("Missing fail_if_bump_needed reference!")
+	}
+
+	if configs.PlanOutputPath != "" && configs.Mode != "plan" {
+		return "Plan output path is only used in plan mode.", errors.New("Plan output path set outside plan mode!")
+	}
+
+	if len(configs.fileGlobs()) == 0 {
+		return "File glob must not be empty, e.g. build.gradle or build.gradle,version.gradle.", errors.New("Missing file_glob!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.MissingFileBehavior, []string{"fail", "skip"}) {
+		return "Missing file behavior must be fail or skip.", errors.New("Invalid missing_file_behavior!")
+	}
+
+	for _, pattern := range configs.allowedBranches() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Sprintf("Allowed branch pattern %s is not a valid glob: %s", pattern, err), errors.New("Invalid allowed_branches!")
+		}
+	}
+
+	for _, pattern := range []string{configs.IncludePathPattern, configs.ExcludePathPattern} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Sprintf("Path pattern %s is not a valid regular expression: %s", pattern, err), errors.New("Invalid include_path_pattern or exclude_path_pattern!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.VersionSource, []string{"gradle", "buildsrc", "pubspec", "properties", "xml"}) {
+		return "Version source must be one of: gradle, buildsrc, pubspec, properties, xml.", errors.New("Invalid version source!")
+	}
+
+	if configs.VersionSource == "properties" {
+		if !isPropertiesFile(configs.PropertiesFile) {
+			return fmt.Sprintf("File %s must have the .properties extension.", configs.PropertiesFile), errors.New("Invalid properties_file!")
+		}
+	}
+	for _, key := range []string{configs.PropertiesNameKey, configs.PropertiesCodeKey} {
+		if !propertiesKeyRegexp.MatchString(key) {
+			return fmt.Sprintf("Properties key %q must be a property name, e.g. versionName or app.version.name.", key), errors.New("Invalid properties_name_key or properties_code_key!")
+		}
+	}
+	if configs.PropertiesNameKey == configs.PropertiesCodeKey {
+		return "Properties name and code keys must differ.", errors.New("Invalid properties_name_key or properties_code_key!")
+	}
+
+	if configs.VersionSource == "xml" {
+		if !isXMLFile(configs.XMLFile) {
+			return fmt.Sprintf("File %s must have the .xml extension.", configs.XMLFile), errors.New("Invalid xml_file!")
+		}
+	}
+	for _, selector := range []string{configs.XMLNameElement, configs.XMLCodeElement} {
+		if _, err := parseXMLSelector(selector, ""); err != nil {
+			return err.Error() + ", e.g. string[@name=version_name].", errors.New("Invalid xml_name_element or xml_code_element!")
+		}
+	}
+
+	if configs.VersionSource == "pubspec" && configs.BuildMetadataEnv != "" {
+		return "The part after + in a pubspec version is the versionCode, it can't carry build metadata.", errors.New("Build metadata with pubspec!")
+	}
+
+	if configs.VersionSource == "buildsrc" && (configs.BuildSrcNameConstant == "" || configs.BuildSrcCodeConstant == "") {
+		return "Both buildsrc_name_constant and buildsrc_code_constant must be set.", errors.New("Missing buildSrc constant name!")
+	}
+
+	if configs.GradleFilePath != "" && configs.Module != "" {
+		return "Set either gradle_file_path or module, not both.", errors.New("Conflicting build file inputs!")
+	}
+
+	if configs.ModuleBumpTypes != "" {
+		moduleBumpTypes, err := parseKeyValueList(configs.ModuleBumpTypes)
+		if err != nil {
+			return "Module bump types must be a comma separated list of module=bump_type pairs, e.g. app=minor,wear=patch.", err
+		}
+		for module, bumpType := range moduleBumpTypes {
+			if !sliceutil.IsStringInSlice(bumpType, bumpTypes) {
+				return fmt.Sprintf("Bump type of module %s must be one of: %s.", module, strings.Join(bumpTypes, ", ")), fmt.Errorf("%w (module_bump_types)", ErrInvalidBumpType)
+			}
+		}
+		if err := outputKeyCollision(outputKeys, sortedModules(moduleBumpTypes)); err != nil {
+			return err.Error() + ".", errors.New("Colliding module outputs!")
+		}
+		if configs.GradleFilePath != "" || configs.Module != "" || configs.CodeFile != "" || configs.NameFile != "" || configs.VersionSource != "gradle" {
+			return "Module bump types select the build files, don't combine them with gradle_file_path, module, code_file, name_file or version_source buildsrc.", errors.New("Conflicting build file inputs!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.MakeWritable, []string{"true", "false"}) {
+		return "Make writable must be true or false.", errors.New("Invalid make_writable!")
+	}
+
+	if _, err := configs.lockTimeout(); err != nil {
+		return "Lock timeout must be a non-negative number of seconds, e.g. 10.", errors.New("Invalid lock_timeout!")
+	}
+
+	if configs.BuildMetadataEnv != "" {
+		if configs.BumpType == "metadata-increment" {
+			return "Bump type metadata-increment bumps the build metadata of the versionName, build_metadata_env would replace it.", errors.New("Build metadata with metadata-increment!")
+		}
+		if os.Getenv(configs.BuildMetadataEnv) == "" {
+			return fmt.Sprintf("Environment variable %s is empty or not set.", configs.BuildMetadataEnv), errors.New("Missing build metadata!")
+		}
+		metadata := configs.buildMetadata()
+		if !isValidSemverIdentifiers(metadata) {
+			return fmt.Sprintf("Build metadata %q must be dot separated identifiers of [0-9A-Za-z-].", metadata), errors.New("Invalid build metadata!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.NameFromBuild, []string{"true", "false"}) {
+		return "Name from build must be true or false.", errors.New("Invalid name_from_build!")
+	}
+	if configs.NameFromBuild == "true" {
+		if !nameBaseRegexp.MatchString(configs.NameBase) {
+			return fmt.Sprintf("Name base %q must be major.minor, e.g. 1.2.", configs.NameBase), errors.New("Invalid name_base!")
+		}
+		if _, err := configs.nameFromBuild(); err != nil {
+			return err.Error(), errors.New("Invalid build number!")
+		}
+	}
+
+	suffixes, err := parseKeyValueList(configs.EnvironmentSuffixes)
+	if err != nil {
+		return "Environment suffixes must be a comma separated list of environment=suffix pairs.", err
+	}
+	for environment, suffix := range suffixes {
+		if suffix != "" && !isValidSemverIdentifiers(suffix) {
+			return fmt.Sprintf("Suffix %q of environment %s must be dot separated identifiers of [0-9A-Za-z-].", suffix, environment), errors.New("Invalid environment suffix!")
+		}
+	}
+	if _, ok := suffixes[configs.Environment]; configs.Environment != "" && !ok {
+		return fmt.Sprintf("Environment %s has no entry in environment_suffixes.", configs.Environment), errors.New("Unknown environment!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.PreserveComponentCount, []string{"true", "false"}) {
+		return "Preserve component count must be true or false.", errors.New("Invalid preserve_component_count!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.StripMetadataOnWrite, []string{"true", "false"}) {
+		return "Strip build metadata on write must be true or false.", errors.New("Invalid strip_build_metadata_on_write!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.PreserveLeadingZeros, []string{"true", "false"}) {
+		return "Preserve leading zeros must be true or false.", errors.New("Invalid preserve_leading_zeros!")
+	}
+
+	if _, err := configs.flavorOffsets(); err != nil {
+		return "Flavor offsets must be a comma separated list of flavor=offset pairs with non-negative integer offsets, e.g. arm64=2,x86=1.", err
+	}
+
+	if _, ok := versionParsers[configs.VersionFormat]; !ok {
+		return "Version format must be one of: semver, v-semver.", errors.New("Invalid version format!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.GitConfigScope, []string{"command", "local"}) {
+		return "Git config scope must be command or local.", errors.New("Invalid git_config_scope!")
+	}
+
+	if configs.GitConfigScope == "local" && configs.GitAuthorName == "" && configs.GitAuthorEmail == "" {
+		return "Git config scope local needs git_author_name or git_author_email.", errors.New("Local git config without author!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.RestoreConfig, []string{"true", "false"}) {
+		return "Restore git config must be true or false.", errors.New("Invalid restore_git_config!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.Signoff, []string{"true", "false"}) {
+		return "Signoff must be true or false.", errors.New("Invalid signoff!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.Amend, []string{"true", "false"}) {
+		return "Amend must be true or false.", errors.New("Invalid amend!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ForceWithLease, []string{"true", "false"}) {
+		return "Force with lease must be true or false.", errors.New("Invalid force_with_lease!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.SetUpstream, []string{"true", "false"}) {
+		return "Set upstream must be true or false.", errors.New("Invalid set_upstream!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.PushTags, []string{"true", "false"}) {
+		return "Push tags must be true or false.", errors.New("Invalid push_tags!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.TagPushMode, []string{"explicit", "follow-tags"}) {
+		return "Tag push mode must be explicit or follow-tags.", errors.New("Invalid tag_push_mode!")
+	}
+
+	if configs.CodeTagTemplate != "" {
+		if !strings.Contains(configs.CodeTagTemplate, "{version_code}") {
+			return "Code tag template must contain {version_code}, e.g. build-{version_code}.", errors.New("Invalid code_tag_template!")
+		}
+		if sample := renderTemplate(configs.CodeTagTemplate, Versions{Name: "1.0.0", Code: 1}); !isValidTagName(sample) {
+			return fmt.Sprintf("Code tag template renders to %s, which is not a valid git tag name.", sample), errors.New("Invalid code_tag_template!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.OnExistingTag, []string{"fail", "skip", "overwrite"}) {
+		return "On existing tag must be one of: fail, skip, overwrite.", errors.New("Invalid on_existing_tag!")
+	} else if configs.OnExistingTag == "overwrite" && configs.PushTags == "true" && configs.TagPushMode == "follow-tags" {
+		return "--follow-tags can't force push an overwritten tag, set tag_push_mode to explicit.", errors.New("Overwriting tags with follow-tags!")
+	}
+
+	if configs.TagScope != "" {
+		if sample := configs.TagScope + "1.0.0"; !isValidTagName(sample) {
+			return fmt.Sprintf("Tag scope gives tags like %s, which is not a valid git tag name.", sample), errors.New("Invalid tag_scope!")
+		}
+	}
+
+	if seconds, err := strconv.Atoi(configs.TagPushDelay); err != nil || seconds < 0 {
+		return "Tag push delay must be a non-negative number of seconds, e.g. 0 or 30.", errors.New("Invalid tag_push_delay!")
+	}
+
+	if retries, err := strconv.Atoi(configs.ReleaseRetries); err != nil || retries < 0 {
+		return "Release retries must be a non-negative integer, e.g. 0 or 3.", errors.New("Invalid release_retries!")
+	} else if retries > 0 && (configs.DoCommit != "true" || configs.Amend == "true") {
+		return "Release retries rebase the bump commit, they need do_commit true and amend false.", errors.New("Release retries without a bump commit!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.Unshallow, []string{"true", "false"}) {
+		return "Unshallow must be true or false.", errors.New("Invalid unshallow!")
+	}
+
+	for input, value := range map[string]string{
+		"do_commit":      configs.DoCommit,
+		"do_push_branch": configs.DoPushBranch,
+		"do_merge":       configs.DoMerge,
+		"do_tag":         configs.DoTag,
+		"merge_no_ff":    configs.MergeNoFF,
+
+		"post_bump_merge_back": configs.PostBumpMergeBack,
+	} {
+		if !sliceutil.IsStringInSlice(value, []string{"true", "false"}) {
+			return fmt.Sprintf("%s must be true or false.", input), fmt.Errorf("Invalid %s!", input)
+		}
+	}
+
+	if configs.DoCommit != "true" && configs.Amend == "true" {
+		return "Amend needs do_commit to be true.", errors.New("Amend without commit!")
+	}
+
+	if configs.DoMerge != "true" && configs.MergeNoFF == "true" {
+		return "Merge no-ff only applies to the gitflow merge, it needs do_merge to be true.", errors.New("Merge no-ff without merge!")
+	}
+
+	if configs.CheckoutBranch != "" && !isValidBranchName(configs.CheckoutBranch) {
+		return fmt.Sprintf("Checkout branch %s is not a valid git branch name.", configs.CheckoutBranch), errors.New("Invalid checkout_branch!")
+	}
+	if configs.CheckoutBranch != "" && configs.Mode == "plan" {
+		// plan runs no git commands, checking out would fetch and move HEAD
+		return "Checkout branch can't be used in plan mode, it runs no git commands. Check the branch out in an earlier step, or use export_only.", errors.New("Checkout branch in plan mode!")
+	}
+
+	if configs.MergeMessage != "" && strings.TrimSpace(configs.MergeMessage) == "" {
+		return "Merge message must not be blank, e.g. Merge release {version_name}, or empty for git's default.", errors.New("Invalid merge_message!")
+	}
+
+	if configs.PostBumpMergeBack == "true" {
+		if configs.DoCommit != "true" {
+			return "Post bump merge back needs do_commit to be true, otherwise there is no bump to merge back.", errors.New("Merge back without commit!")
+		}
+		if strings.TrimSpace(configs.MergeBackBranch) == "" {
+			return "Merge back branch must not be empty, e.g. develop.", errors.New("Missing merge_back_branch!")
+		}
+	}
+
+	if strings.TrimSpace(configs.CommitMessage) == "" {
+		return "Commit message must not be empty, e.g. Bump version to {version_name}.", errors.New("Missing commit_message!")
+	}
+
+	if configs.CommitType != "" && !commitTypeRegexp.MatchString(configs.CommitType) {
+		return "Commit type must be a lowercase conventional commit type, e.g. chore.", errors.New("Invalid commit_type!")
+	}
+
+	if configs.CommitBody != "" && strings.TrimSpace(configs.CommitBody) == "" {
+		return "Commit body must not be only whitespace, leave it empty for a subject only message.", errors.New("Invalid commit_body!")
+	}
+
+	if configs.CommitBody != "" && configs.Amend == "true" {
+		return "Commit body is not used with amend, which keeps the amended commit's message.", errors.New("Commit body with amend!")
+	}
+
+	if configs.CommitDate != "" {
+		if _, err := time.Parse(time.RFC3339, configs.CommitDate); err != nil {
+			return "Commit date must be an RFC 3339 timestamp, e.g. 2024-01-31T12:00:00Z.", errors.New("Invalid commit_date!")
+		}
+	}
+
+	for _, coAuthor := range nonEmptyLines(configs.CoAuthors) {
+		if !coAuthorRegexp.MatchString(coAuthor) {
+			return "Co-authors must be newline separated Name <email> lines, e.g. Jane Doe <jane@example.com>.", fmt.Errorf("Invalid co-author: %s", coAuthor)
+		}
+	}
+
+	for _, trailer := range nonEmptyLines(configs.CommitTrailers) {
+		if !commitTrailerRegexp.MatchString(trailer) {
+			return "Commit trailers must be newline separated Key: value lines, e.g. Skip-Release: true.", fmt.Errorf("Invalid commit trailer: %s", trailer)
+		}
+	}
+
+	if strings.TrimSpace(configs.MergeBranch) == "" {
+		return "Merge branch must not be empty, e.g. develop or release/{version_name}.", errors.New("Missing merge_branch!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.SkipIfLastCommitIsBump, []string{"true", "false"}) {
+		return "Skip if last commit is bump must be true or false.", errors.New("Invalid skip_if_last_commit_is_bump!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.RequireCleanTree, []string{"true", "false"}) {
+		return "Require clean tree must be true or false.", errors.New("Invalid require_clean_tree!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.CheckTagOrder, []string{"true", "false"}) {
+		return "Check tag order must be true or false.", errors.New("Invalid check_tag_order!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.VersionFromTag, []string{"true", "false"}) {
+		return "Version from tag must be true or false.", errors.New("Invalid version_from_tag!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.VersionFromDescribe, []string{"true", "false"}) {
+		return "Version from describe must be true or false.", errors.New("Invalid version_from_describe!")
+	}
+	if configs.VersionFromDescribe == "true" && configs.VersionFromTag == "true" {
+		return "Set either version_from_tag or version_from_describe, not both.", errors.New("Conflicting version tag inputs!")
+	}
+	if !sliceutil.IsStringInSlice(configs.DescribeFallback, []string{"file", "fail"}) {
+		return "Describe fallback must be file or fail.", errors.New("Invalid describe_fallback!")
+	}
+
+	if configs.RemoteVersionURL != "" {
+		if u, err := url.Parse(configs.RemoteVersionURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return "Remote version URL must be an http or https URL, e.g. https://example.com/app/build.gradle.", errors.New("Invalid remote_version_url!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ListMatches, []string{"true", "false"}) {
+		return "List matches must be true or false.", errors.New("Invalid list_matches!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ExportUnchangedName, []string{"true", "false"}) {
+		return "Export unchanged version name must be true or false.", errors.New("Invalid export_unchanged_version_name!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.RequireVersionName, []string{"true", "false"}) {
+		return "Require version name must be true or false.", errors.New("Invalid require_version_name!")
+	}
+
+	if configs.ExtraCodeField != "" {
+		if !extraCodeFieldRegexp.MatchString(configs.ExtraCodeField) || sliceutil.IsStringInSlice(configs.ExtraCodeField, []string{"versionCode", "versionName"}) {
+			return "Extra code field must be a property name other than versionCode and versionName, e.g. buildNumber.", errors.New("Invalid extra_code_field!")
+		}
+		if configs.VersionSource == "pubspec" {
+			return "A pubspec has no extra numeric fields next to its version.", errors.New("Extra code field with pubspec!")
+		}
+	}
+
+	if !sliceutil.IsStringInSlice(configs.VersionFileCode, []string{"true", "false"}) {
+		return "Version file code must be true or false.", errors.New("Invalid version_file_code!")
+	}
+
+	if configs.VersionConsumer != "" {
+		// the command writes the versions instead of the step, so the step writes none of these files either
+		for _, input := range []struct{ name, value string }{
+			{"write_version_file", configs.WriteVersionFile},
+			{"shared_version_files", configs.SharedVersionFiles},
+			{"pubspec_sync_gradle_file", configs.PubspecSyncGradle},
+		} {
+			if input.value != "" {
+				return fmt.Sprintf("Version consumer command writes the versions instead of the step, it can't be combined with %s. Write that file in the command too.", input.name), fmt.Errorf("Version consumer command with %s!", input.name)
+			}
+		}
+	}
+
+	if configs.SharedVersionFiles != "" && configs.ModuleBumpTypes != "" {
+		return "Shared version files carry the version of a single bumped file, they can't be combined with module_bump_types.", errors.New("Shared version files with module_bump_types!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ForceResync, []string{"true", "false"}) {
+		return "Force resync must be true or false.", errors.New("Invalid force_resync!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.ExportEnvman, []string{"true", "false"}) {
+		return "Export envman must be true or false.", errors.New("Invalid export_envman!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.EnvmanFailure, []string{"fail", "warn"}) {
+		return "Envman failure must be fail or warn.", errors.New("Invalid envman_failure!")
+	}
+
+	if !sliceutil.IsStringInSlice(configs.GitHubOutput, []string{"auto", "true", "false"}) {
+		return "GitHub output must be auto, true or false.", errors.New("Invalid github_output!")
+	}
+	if configs.GitHubOutput == "true" && os.Getenv("GITHUB_OUTPUT") == "" {
+		return "GitHub output is true, but GITHUB_OUTPUT is not set, it's only set inside GitHub Actions.", errors.New("Missing GITHUB_OUTPUT!")
+	}
+
+	return "", nil
+}
+
+// validateFiles checks the files the inputs name exist, it runs after checkout_branch is checked out
+// as they may only exist on that branch.
+func (configs ConfigsModel) validateFiles() (string, error) {
+	type inputFile struct{ input, file string }
+	var files []inputFile
+	switch configs.VersionSource {
+	case "properties":
+		files = append(files, inputFile{"properties_file", configs.PropertiesFile})
+	case "xml":
+		files = append(files, inputFile{"xml_file", configs.XMLFile})
+	case "pubspec":
+		files = append(files, inputFile{"pubspec_file", configs.PubspecFile}, inputFile{"pubspec_sync_gradle_file", configs.PubspecSyncGradle})
+	case "buildsrc":
+		files = append(files, inputFile{"buildsrc_file", configs.BuildSrcFile})
+	}
+	files = append(files, inputFile{"code_file", configs.CodeFile}, inputFile{"name_file", configs.NameFile})
+	for _, file := range configs.sharedVersionFiles() {
+		files = append(files, inputFile{"shared_version_files", file})
+	}
+
+	for _, file := range files {
+		if file.file == "" {
+			continue
+		}
+		if exist, err := pathutil.IsPathExists(file.file); err != nil {
+			return "", err
+		} else if !exist {
+			return fmt.Sprintf("File %s does not exist.", file.file), fmt.Errorf("Invalid %s!", file.input)
+		}
+	}
+	if configs.WriteVersionFile != "" {
+		if err := checkWritable(configs.WriteVersionFile); err != nil {
+			return fmt.Sprintf("Version file %s is not writable: %s.", configs.WriteVersionFile, err), errors.New("Invalid write_version_file!")
+		}
+	}
+
+	return "", nil
+}
+
+func (configs ConfigsModel) buildMetadata() string {
+	if configs.BuildMetadataEnv == "" {
+		return ""
+	}
+
+	return configs.BuildMetadataPrefix + os.Getenv(configs.BuildMetadataEnv)
+}
+
+var nameBaseRegexp = regexp.MustCompile(`^\d+\.\d+$`)
+
+// nameFromBuild appends the build number read from build_number_env to name_base as the patch, e.g. `1.2.457`.
+func (configs ConfigsModel) nameFromBuild() (*semver.Version, error) {
+	build := os.Getenv(configs.BuildNumberEnv)
+	number, err := strconv.Atoi(build)
+	if err != nil || number < 0 {
+		return nil, fmt.Errorf("Build number %q of environment variable %s must be a non-negative integer.", build, configs.BuildNumberEnv)
+	}
+
+	return semver.NewVersion(fmt.Sprintf("%s.%d", configs.NameBase, number))
+}
+
+var semverIdentifierRegexp = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+func isValidSemverIdentifiers(identifiers string) bool {
+	for _, identifier := range strings.Split(identifiers, ".") {
+		if !semverIdentifierRegexp.MatchString(identifier) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseKeyValueList parses comma separated key=value pairs, e.g. `staging=staging,production=`.
+func parseKeyValueList(list string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		split := strings.SplitN(item, "=", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" {
+			return map[string]string{}, fmt.Errorf("Invalid key=value pair: %s", item)
+		}
+		values[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+	}
+
+	return values, nil
+}
+
+// pushes tells whether the run pushes anything to the remotes.
+func (configs ConfigsModel) pushes() bool {
+	return configs.DoPushBranch == "true" || configs.DoMerge == "true" || (configs.DoTag == "true" && configs.PushTags == "true")
+}
+
+func (configs ConfigsModel) tagPushDelay() time.Duration {
+	seconds, err := strconv.Atoi(configs.TagPushDelay)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func (configs ConfigsModel) lockTimeout() (time.Duration, error) {
+	seconds, err := strconv.Atoi(configs.LockTimeout)
+	if err != nil {
+		return 0, err
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("Negative lock timeout: %d", seconds)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// componentOverrides returns the components set with set_major, set_minor and set_patch,
+// they replace the bumped ones, e.g. a minor bump of 1.2.3 with set_patch 5 gives 1.3.5.
+func (configs ConfigsModel) componentOverrides() (map[string]int64, error) {
+	overrides := map[string]int64{}
+	for component, value := range map[string]string{
+		"major": configs.SetMajor,
+		"minor": configs.SetMinor,
+		"patch": configs.SetPatch,
+	} {
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number < 0 {
+			return map[string]int64{}, fmt.Errorf("Invalid set_%s!", component)
+		}
+		overrides[component] = number
+	}
+
+	return overrides, nil
+}
+
+// checkCoupledBump fails if only one of versionName and versionCode changes, e.g. when finalize
+// keeps a release versionName or min_version_code already is above the increment.
+func checkCoupledBump(old, bumped Versions) error {
+	nameChanged := bumped.Name != old.Name
+	codeChanged := bumped.Code != old.Code
+	if nameChanged && !codeChanged {
+		return fmt.Errorf("versionName changes from %s to %s, but versionCode stays %d, require_coupled_bump needs both to change", old.Name, bumped.Name, old.Code)
+	}
+	if codeChanged && !nameChanged {
+		return fmt.Errorf("versionCode changes from %d to %d, but versionName stays %s, require_coupled_bump needs both to change", old.Code, bumped.Code, valueOrNone(old.Name))
+	}
+
+	return nil
+}
+
+// isAtExplicitVersion tells whether the name already is the one the bump to the version set with set_major,
+// set_minor and set_patch would write, e.g. not for `1.2.3-rc.1` when the bump writes `1.2.3`.
+// Only the environment suffix isn't compared.
+func (configs ConfigsModel) isAtExplicitVersion(name string) (bool, error) {
+	target, err := bumpVersionName(configs, name)
+	if err != nil {
+		return false, err
+	}
+
+	parser := versionParserFor(configs)
+	suffixes := configs.environmentSuffixes()
+	current, err := parser.Parse(name)
+	if err != nil {
+		return false, err
+	}
+	bumped, err := parser.Parse(target)
+	if err != nil {
+		return false, err
+	}
+	current.PreRelease = semver.PreRelease(stripEnvironmentSuffix(string(current.PreRelease), suffixes))
+	bumped.PreRelease = semver.PreRelease(stripEnvironmentSuffix(string(bumped.PreRelease), suffixes))
+
+	return isSameVersion(*current, *bumped), nil
+}
+
+// moduleBumpTypes parses module_bump_types, e.g. `app=minor,wear=patch`.
+func (configs ConfigsModel) moduleBumpTypes() map[string]string {
+	bumpTypes, err := parseKeyValueList(configs.ModuleBumpTypes)
+	if err != nil {
+		return map[string]string{}
+	}
+
+	return bumpTypes
+}
+
+func sortedModules(bumpTypes map[string]string) []string {
+	modules := []string{}
+	for module := range bumpTypes {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+
+	return modules
+}
+
+// forBuildGradleFile returns the configs with the bump type and the tag namespace of the module owning the file,
+// and the module.
+func (configs ConfigsModel) forBuildGradleFile(buildGradleFile string) (ConfigsModel, string) {
+	bumpTypes := configs.moduleBumpTypes()
+	for _, module := range sortedModules(bumpTypes) {
+		if file, err := findModuleBuildGradleFile(".", module); err == nil && file == buildGradleFile {
+			configs.BumpType = bumpTypes[module]
+			// every module tags in its own namespace, e.g. `wear-1.2.4`, the modules' versions may be the same
+			configs.TagScope = moduleTagPrefix(module) + configs.TagScope
+			if configs.CodeTagTemplate != "" {
+				configs.CodeTagTemplate = moduleTagPrefix(module) + configs.CodeTagTemplate
+			}
+			return configs, module
+		}
+	}
+
+	return configs, ""
+}
+
+var nonAlphanumericRegexp = regexp.MustCompile(`[^0-9A-Za-z]+`)
+
+// moduleTagPrefix prefixes the tags of a module bumped with module_bump_types, e.g. `feature-wear-` for
+// :feature:wear. Modules with the same prefix are rejected as their qualified outputs are the same too.
+func moduleTagPrefix(module string) string {
+	return strings.ToLower(nonAlphanumericRegexp.ReplaceAllString(strings.Trim(module, ":"), "-")) + "-"
+}
+
+// moduleOutputKey qualifies an output with the module, e.g. BUMP_VERSION_NAME_FEATURE_WEAR for :feature:wear.
+func moduleOutputKey(key, module string) string {
+	return key + "_" + strings.ToUpper(nonAlphanumericRegexp.ReplaceAllString(strings.Trim(module, ":"), "_"))
+}
+
+// outputKeyCollision fails for the first module whose keys qualified with it are the keys of another module,
+// e.g. `feature:wear` and `feature-wear`, which both export BUMP_VERSION_NAME_FEATURE_WEAR, or one of the
+// unqualified keys, and for a module without letters or digits to qualify the keys with, e.g. `:`.
+func outputKeyCollision(keys, modules []string) error {
+	owners := map[string]string{}
+	for _, key := range keys {
+		owners[key] = ""
+	}
+
+	for _, module := range modules {
+		if strings.Trim(moduleOutputKey("", module), "_") == "" {
+			return fmt.Errorf("Module %s has no letters or digits to qualify its outputs with", module)
+		}
+		for _, key := range keys {
+			qualified := moduleOutputKey(key, module)
+			owner, ok := owners[qualified]
+			if ok && owner == "" {
+				return fmt.Errorf("Module %s exports %s, which is reserved for the unqualified output", module, qualified)
+			}
+			if ok {
+				return fmt.Errorf("Modules %s and %s both export %s, one would overwrite the other", owner, module, qualified)
+			}
+			owners[qualified] = module
+		}
+	}
+
+	return nil
+}
+
+func (configs ConfigsModel) environmentSuffixes() map[string]string {
+	suffixes, err := parseKeyValueList(configs.EnvironmentSuffixes)
+	if err != nil {
+		return map[string]string{}
+	}
+
+	return suffixes
+}
+
+func (configs ConfigsModel) flavorOffsets() (map[string]int, error) {
+	values, err := parseKeyValueList(configs.FlavorOffsets)
+	if err != nil {
+		return map[string]int{}, err
+	}
+
+	offsets := map[string]int{}
+	for flavor, value := range values {
+		offset, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return map[string]int{}, fmt.Errorf("Invalid offset of flavor %s: %s", flavor, value)
+		}
+		if offset < 0 {
+			return map[string]int{}, fmt.Errorf("Negative offset of flavor %s: %d", flavor, offset)
+		}
+		offsets[flavor] = int(offset)
+	}
+
+	return offsets, nil
+}
+
+func sortedFlavors(codes map[string]int) []string {
+	flavors := []string{}
+	for flavor := range codes {
+		flavors = append(flavors, flavor)
+	}
+	sort.Strings(flavors)
+
+	return flavors
+}
+
+// stripEnvironmentSuffix removes a trailing environment suffix identifier from the pre-release,
+// so suffixes written by previous bumps don't compound.
+func stripEnvironmentSuffix(preRelease string, suffixes map[string]string) string {
+	for _, suffix := range suffixes {
+		if suffix == "" {
+			continue
+		}
+		if preRelease == suffix {
+			return ""
+		}
+		if strings.HasSuffix(preRelease, "."+suffix) {
+			return strings.TrimSuffix(preRelease, "."+suffix)
+		}
+	}
+
+	return preRelease
+}
+
+func appendEnvironmentSuffix(preRelease, suffix string) string {
+	if suffix == "" {
+		return preRelease
+	}
+	if preRelease == "" {
+		return suffix
+	}
+
+	return preRelease + "." + suffix
+}
+
+// Errors the step fails with, match them with errors.Is as they may be wrapped.
+var (
+	ErrInvalidBumpType     = errors.New("Invalid bump type!")
+	ErrFileNotFound        = errors.New("No `build.gradle` file found")
+	ErrMultipleFiles       = errors.New("Found more than one `build.gradle` file")
+	ErrGradleFileNotExist  = errors.New("Configured `gradle_file_path` does not exist")
+	ErrVersionNameNotFound = errors.New("Failed to match `versionName`")
+	ErrVersionCodeNotFound = errors.New("Failed to match `versionCode`")
+	ErrFileNotWritable     = errors.New("File is read-only")
+
+	ErrVersionNameConcatenated = errors.New("`versionName` is built with string concatenation, which can't be bumped")
+	ErrVersionNameConditional  = errors.New("`versionName` is a conditional expression")
+	ErrVersionNameConflicting  = errors.New("`versionName` is declared more than once with different values")
+)
+
+var hints = map[error]string{
+	ErrFileNotFound:        "run the step from the project root or set gradle_file_path to the module's build.gradle",
+	ErrMultipleFiles:       "set module (e.g. app) or gradle_file_path (e.g. app/build.gradle) to choose the file",
+	ErrGradleFileNotExist:  "gradle_file_path is resolved relative to the working directory, check the path and the working directory",
+	ErrVersionNameNotFound: "ensure versionName uses the pattern versionName \"X.Y.Z\" with a string literal",
+	ErrVersionCodeNotFound: "ensure versionCode uses the pattern versionCode N with an integer literal",
+	ErrModuleNotIncluded:   "module must match an `include` entry of settings.gradle or settings.gradle.kts, e.g. app or :app, included builds (includeBuild) can't be bumped as a module",
+	ErrModuleFileNotExist:  "check the module's projectDir mapping in settings.gradle or settings.gradle.kts or set gradle_file_path instead",
+	ErrFileNotWritable:     "set make_writable to true to make the file writable for the bump, or fix its permissions on the agent",
+
+	ErrVersionNameConcatenated: "replace e.g. versionName \"1.2.\" + patchNumber with a single literal versionName \"1.2.3\" or a variable holding the full version",
+	ErrVersionNameConditional:  "set conditional_branch to true or false to bump the literal after ? or after :, both must be literals, e.g. versionName isRelease ? \"1.2.3\" : \"1.2.3-dev\"",
+	ErrVersionNameConflicting:  "keep a single versionName, e.g. in defaultConfig, and use versionNameSuffix in the product flavors",
+}
+
+func hintFor(err error) string {
+	for hintErr, hint := range hints {
+		if errors.Is(err, hintErr) {
+			return hint
+		}
+	}
+
+	return ""
+}
+
+func failWithHint(err error, format string, v ...interface{}) {
+	log.Error(format, v...)
+	if hint := hintFor(err); hint != "" {
+		log.Warn("Hint: %s", hint)
+	}
+	log.Exit(1)
+}
+
+// fileGlobs splits the comma separated file_glob input.
+func (configs ConfigsModel) fileGlobs() []string {
+	globs := []string{}
+	for _, glob := range strings.Split(configs.FileGlob, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			globs = append(globs, glob)
+		}
+	}
+
+	return globs
+}
+
+// sharedVersionFiles splits the comma separated shared_version_files input.
+func (configs ConfigsModel) sharedVersionFiles() []string {
+	files := []string{}
+	for _, file := range strings.Split(configs.SharedVersionFiles, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// checkSharedVersions fails if a shared version file carries other versions than the bumped file,
+// listing every divergence. With force_resync the divergence is only logged, the bump overwrites it.
+func checkSharedVersions(configs ConfigsModel, file string, versions Versions) error {
+	divergences := []string{}
+	for _, shared := range configs.sharedVersionFiles() {
+		sharedVersions, err := getVersionsFromFile(configs, shared)
+		if err != nil {
+			return fmt.Errorf("%s: %s", shared, err)
+		}
+		if sharedVersions.Name != versions.Name {
+			divergences = append(divergences, fmt.Sprintf("%s: versionName %s, %s has %s", shared, sharedVersions.Name, file, versions.Name))
+		}
+		if sharedVersions.Code != versions.Code {
+			divergences = append(divergences, fmt.Sprintf("%s: versionCode %d, %s has %d", shared, sharedVersions.Code, file, versions.Code))
+		}
+	}
+	if len(divergences) == 0 {
+		return nil
+	}
+
+	if configs.ForceResync == "true" {
+		log.Warn("Shared version files diverged, resyncing them to the bumped versions:")
+		for _, divergence := range divergences {
+			log.Detail("%s", divergence)
+		}
+		return nil
+	}
+
+	log.Error("Shared version files diverged:")
+	for _, divergence := range divergences {
+		log.Detail("%s", divergence)
+	}
+	return errors.New("Shared version files don't carry the same versions, align them or set force_resync to true")
+}
+
+// allowedBranches splits the comma separated allowed_branches input, empty allows every branch.
+func (configs ConfigsModel) allowedBranches() []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(configs.AllowedBranches, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// gitRemotes splits the comma separated git_remotes input, empty pushes to origin only.
+// The first remote is the primary one, fetched from and checked, the others get the same pushes.
+func (configs ConfigsModel) gitRemotes() []string {
+	remotes := []string{}
+	for _, remote := range strings.Split(configs.GitRemotes, ",") {
+		if remote = strings.TrimSpace(remote); remote != "" {
+			remotes = append(remotes, remote)
+		}
+	}
+	if len(remotes) == 0 {
+		return []string{"origin"}
+	}
+
+	return remotes
+}
+
+func (configs ConfigsModel) primaryRemote() string {
+	return configs.gitRemotes()[0]
+}
+
+// isBranchAllowed matches the branch against the allowed_branches globs, e.g. `release/*`.
+func (configs ConfigsModel) isBranchAllowed(branch string) bool {
+	patterns := configs.allowedBranches()
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterPaths keeps the searched files matching include_path_pattern and not matching exclude_path_pattern,
+// e.g. to skip generated copies in build/intermediates.
+func (configs ConfigsModel) filterPaths(files []string) []string {
+	include := regexp.MustCompile(configs.IncludePathPattern)
+
+	filtered := []string{}
+	for _, file := range files {
+		slashed := filepath.ToSlash(filepath.Clean(file))
+		if !include.MatchString(slashed) {
+			log.Detail("%s does not match include_path_pattern, skipping", file)
+			continue
+		}
+		if configs.ExcludePathPattern != "" && regexp.MustCompile(configs.ExcludePathPattern).MatchString(slashed) {
+			log.Detail("%s matches exclude_path_pattern, skipping", file)
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+
+	return filtered
+}
+
+func find(dir string, nameIncludes []string) ([]string, error) {
+	cmdSlice := []string{"grep"}
+	cmdSlice = append(cmdSlice, "-l")
+	cmdSlice = append(cmdSlice, "-r", "versionCode")
+	for _, nameInclude := range nameIncludes {
+		cmdSlice = append(cmdSlice, "--include", nameInclude)
+	}
+	cmdSlice = append(cmdSlice, dir)
+
+	log.Detail("%s", command.PrintableCommandArgs(false, cmdSlice))
+
+	out, err := command.New(cmdSlice[0], cmdSlice[1:]...).RunAndReturnTrimmedOutput()
+	if err != nil {
+		// grep exits with 1 if nothing matched
+		if exitCode, codeErr := errorutil.CmdExitCodeFromError(err); codeErr == nil && exitCode == 1 {
+			return []string{}, nil
+		}
+		return []string{}, err
+	}
+
+	split := strings.Split(out, "\n")
+	files := []string{}
+	for _, item := range split {
+		trimmed := strings.TrimSpace(item)
+		if trimmed != "" {
+			files = append(files, trimmed)
+		}
+	}
+
+	return files, nil
+}
+
+// versionFiles returns the files holding versionCode and versionName, defaulting to the build file.
+func (configs ConfigsModel) versionFiles(buildGradleFile string) (string, string) {
+	if file := configs.sourceFile(); file != "" {
+		return file, file
+	}
+
+	codeFile := buildGradleFile
+	if configs.CodeFile != "" {
+		codeFile = configs.CodeFile
+	}
+
+	nameFile := buildGradleFile
+	if configs.NameFile != "" {
+		nameFile = configs.NameFile
+	}
+
+	return codeFile, nameFile
+}
+
+func (configs ConfigsModel) targetFiles(buildGradleFiles []string) []string {
+	files := []string{}
+	for _, buildGradleFile := range buildGradleFiles {
+		codeFile, nameFile := configs.versionFiles(buildGradleFile)
+		fieldFiles, err := versionFieldFiles(configs, codeFile, nameFile)
+		if err != nil {
+			fieldFiles = []string{codeFile, nameFile}
+		}
+		files = append(files, fieldFiles...)
+	}
+	files = append(files, configs.sharedVersionFiles()...)
+	if configs.WriteVersionFile != "" {
+		files = append(files, configs.WriteVersionFile)
+	}
+
+	return sliceutil.UniqueStringSlice(files)
+}
+
+// exportsOutputs reports whether the mode exports outputs, the others only log what they find.
+func (configs ConfigsModel) exportsOutputs() bool {
+	return configs.Mode == "bump" || configs.Mode == "export_only"
+}
+
+// listMatches logs every file the versionCode search finds, regardless of which one gets bumped,
+// and exports them in the modes exporting outputs.
+func listMatches(configs ConfigsModel) error {
+	files, err := find(".", configs.fileGlobs())
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		log.Detail("%s", file)
+	}
+	if len(files) == 0 {
+		log.Detail("no matches")
+	}
+
+	if configs.exportsOutputs() {
+		exportOutput(configs, "BUMP_MATCHED_FILES", strings.Join(files, "\n"))
+	}
+
+	return nil
+}
+
+// sourceFile returns the single file holding both versions for non-gradle version sources.
+func (configs ConfigsModel) sourceFile() string {
+	switch configs.VersionSource {
+	case "buildsrc":
+		return configs.BuildSrcFile
+	case "pubspec":
+		return configs.PubspecFile
+	case "properties":
+		return configs.PropertiesFile
+	case "xml":
+		return configs.XMLFile
+	}
+
+	return ""
+}
+
+func findBuildGradleFiles(configs ConfigsModel) ([]string, error) {
+	if file := configs.sourceFile(); file != "" {
+		return []string{file}, nil
+	}
+
+	if configs.CodeFile != "" && configs.NameFile != "" {
+		return []string{configs.CodeFile}, nil
+	}
+
+	if configs.GradleFilePath != "" {
+		if exist, err := pathutil.IsPathExists(configs.GradleFilePath); err != nil {
+			return []string{}, err
+		} else if !exist {
+			return []string{}, ErrGradleFileNotExist
+		}
+		return []string{configs.GradleFilePath}, nil
+	}
+
+	if configs.ModuleBumpTypes != "" {
+		files := []string{}
+		for _, module := range sortedModules(configs.moduleBumpTypes()) {
+			file, err := findModuleBuildGradleFile(".", module)
+			if err != nil {
+				return []string{}, err
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+
+	if configs.Module != "" {
+		file, err := findModuleBuildGradleFile(".", configs.Module)
+		if err != nil {
+			return []string{}, err
+		}
+		return []string{file}, nil
+	}
+
+	files, err := find(".", configs.fileGlobs())
+	if err != nil {
+		return []string{}, err
+	}
+	files = configs.filterPaths(files)
+
+	if len(files) == 0 {
+		return []string{}, ErrFileNotFound
+	}
+
+	if len(files) != 1 {
+		return []string{}, ErrMultipleFiles
+	}
+
+	return files, nil
+}
+
+// bumpVersions bumps both versions, a missing versionName stays empty.
+func bumpVersions(configs ConfigsModel, versions Versions) (Versions, error) {
+	name := ""
+	if versions.Name != "" {
+		var err error
+		name, err = bumpVersionName(configs, versions.Name)
+		if err != nil {
+			return Versions{}, err
+		}
+	}
+
+	codeIncrement, err := strconv.Atoi(configs.CodeIncrement)
+	if err != nil {
+		return Versions{}, err
+	}
+	if int64(versions.Code)+int64(codeIncrement) > math.MaxInt32 {
+		return Versions{}, fmt.Errorf("versionCode %d overflows int32", int64(versions.Code)+int64(codeIncrement))
+	}
+	code := versions.Code + codeIncrement
+	if configs.MinVersionCode != "" {
+		floor, err := strconv.Atoi(configs.MinVersionCode)
+		if err != nil {
+			return Versions{}, err
+		}
+		// the new versionCode is max(file + code_increment, min_version_code)
+		if code < floor {
+			log.Detail("versionCode %d is below min_version_code, using %d", code, floor)
+			code = floor
+		} else {
+			log.Detail("versionCode %d is at least min_version_code %d, using it", code, floor)
+		}
+	}
+
+	offsets, err := configs.flavorOffsets()
+	if err != nil {
+		return Versions{}, err
+	}
+
+	flavorCodes := map[string]int{}
+	for flavor, offset := range offsets {
+		if int64(code)+int64(offset) > math.MaxInt32 {
+			return Versions{}, fmt.Errorf("versionCode %d of flavor %s overflows int32", int64(code)+int64(offset), flavor)
+		}
+		flavorCodes[flavor] = code + offset
+	}
+
+	extraCode := 0
+	if configs.ExtraCodeField != "" {
+		if int64(versions.ExtraCode)+int64(codeIncrement) > math.MaxInt32 {
+			return Versions{}, fmt.Errorf("%s %d overflows int32", configs.ExtraCodeField, int64(versions.ExtraCode)+int64(codeIncrement))
+		}
+		extraCode = versions.ExtraCode + codeIncrement
+	}
+
+	return Versions{
+		Name:        name,
+		Code:        code,
+		ExtraCode:   extraCode,
+		FlavorCodes: flavorCodes,
+		codeWidth:   versions.codeWidth,
+	}, nil
+}
+
+func bumpVersionName(configs ConfigsModel, name string) (string, error) {
+	parser := versionParserFor(configs)
+	suffixes := configs.environmentSuffixes()
+
+	var versionName *semver.Version
+	var err error
+	if configs.NameFromBuild == "true" {
+		// the build number replaces the bump, the current versionName doesn't matter
+		if versionName, err = configs.nameFromBuild(); err != nil {
+			return "", err
+		}
+	} else {
+		if versionName, err = parser.Parse(name); err != nil {
+			return "", err
+		}
+
+		versionName.PreRelease = semver.PreRelease(stripEnvironmentSuffix(string(versionName.PreRelease), suffixes))
+
+		if err := parser.Bump(versionName, configs.BumpType); err != nil {
+			return "", err
+		}
+	}
+
+	overrides, err := configs.componentOverrides()
+	if err != nil {
+		return "", err
+	}
+	for component, value := range overrides {
+		switch component {
+		case "major":
+			versionName.Major = value
+		case "minor":
+			versionName.Minor = value
+		case "patch":
+			versionName.Patch = value
+		}
+	}
+
+	versionName.PreRelease = semver.PreRelease(appendEnvironmentSuffix(string(versionName.PreRelease), suffixes[configs.Environment]))
+	if configs.BumpType != "metadata-increment" {
+		versionName.Metadata = configs.buildMetadata()
+	}
+
+	return parser.Format(versionName, name), nil
+}
+
+// fetchRemoteVersionName reads the versionName from a Gradle file or a plain version served at the URL.
+func fetchRemoteVersionName(remoteURL string) (string, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(remoteURL)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s returned %s", remoteURL, response.Status)
+	}
+
+	bytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if matches := versionNameRegexp.FindStringSubmatch(string(bytes)); len(matches) == 2 {
+		return matches[1], nil
+	}
+
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// isAheadOfRemote reports whether the local versionName is greater than the remote one, i.e. it was already bumped.
+func isAheadOfRemote(configs ConfigsModel, versions Versions) (bool, error) {
+	remoteName, err := fetchRemoteVersionName(configs.RemoteVersionURL)
+	if err != nil {
+		return false, err
+	}
+
+	parser := versionParserFor(configs)
+	remote, err := parser.Parse(remoteName)
+	if err != nil {
+		return false, fmt.Errorf("remote versionName %q: %s", remoteName, err)
+	}
+	local, err := parser.Parse(versions.Name)
+	if err != nil {
+		return false, err
+	}
+
+	ahead := remote.LessThan(*local)
+	log.Detail("local versionName: %s, remote versionName: %s, local is ahead: %t", versions.Name, remoteName, ahead)
+
+	return ahead, nil
+}
+
+var (
+	commitTypeRegexp    = regexp.MustCompile(`^[a-z]+$`)
+	commitTrailerRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+: \S.*$`)
+	coAuthorRegexp      = regexp.MustCompile(`^[^<>]*[^<>\s] <[^<>\s@]+@[^<>\s@]+>$`)
+)
+
+// commitTrailers splits the newline separated commit_trailers input and adds a Co-authored-by trailer per co-author.
+func (configs ConfigsModel) commitTrailers() []string {
+	trailers := nonEmptyLines(configs.CommitTrailers)
+	for _, coAuthor := range nonEmptyLines(configs.CoAuthors) {
+		trailers = append(trailers, "Co-authored-by: "+coAuthor)
+	}
+
+	return trailers
+}
+
+func nonEmptyLines(value string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// commitMessageTemplate prefixes the message with the conventional commit type,
+// e.g. `chore(release): bump version to {version_name}`.
+func (configs ConfigsModel) commitMessageTemplate() string {
+	if configs.CommitType == "" {
+		return configs.CommitMessage
+	}
+
+	// the first rune, not byte, is lowered, e.g. `Éditer` to `éditer`
+	first, size := utf8.DecodeRuneInString(configs.CommitMessage)
+	message := configs.CommitMessage
+	if size > 0 {
+		message = string(unicode.ToLower(first)) + message[size:]
+	}
+
+	return configs.CommitType + "(release): " + message
+}
 
-	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/sliceutil"
-	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
-	"io/ioutil"
-	"regexp"
-	"github.com/coreos/go-semver/semver"
-	"strconv"
-)
+var templateEnvRegexp = regexp.MustCompile(`\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
 
-type ConfigsModel struct {
-	BumpType string
+// renderTemplate fills in {version_name}, {version_code}, {date} (UTC, e.g. 20240131)
+// and {env:NAME}, the value of the environment variable NAME.
+func renderTemplate(template string, versions Versions) string {
+	rendered := strings.NewReplacer(
+		"{version_name}", versions.Name,
+		"{version_code}", strconv.Itoa(versions.Code),
+		"{date}", time.Now().UTC().Format("20060102"),
+	).Replace(template)
+
+	return templateEnvRegexp.ReplaceAllStringFunc(rendered, func(placeholder string) string {
+		return os.Getenv(templateEnvRegexp.FindStringSubmatch(placeholder)[1])
+	})
 }
 
-type Versions struct {
-	Code int
-	Name string
+// templatePrefix returns the literal part of the template before the first placeholder.
+func templatePrefix(template string) string {
+	if index := strings.Index(template, "{"); index != -1 {
+		return template[:index]
+	}
+
+	return template
+}
+
+var templatePlaceholderRegexp = regexp.MustCompile(`\{version_name\}|\{version_code\}|\{date\}|\{env:[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// templateGlob returns the template with every placeholder replaced by *, e.g. `build-*` for `build-{version_code}`.
+func templateGlob(template string) string {
+	return templatePlaceholderRegexp.ReplaceAllString(template, "*")
 }
 
-func createConfigsModelFromEnvs() ConfigsModel {
-	return ConfigsModel{
-		BumpType: os.Getenv("bump_type"),
+// templatePlaceholderPatterns match what renderTemplate fills in, an {env:NAME} may be anything.
+var templatePlaceholderPatterns = map[string]string{
+	"{version_name}": `v?\d+(?:\.\d+)*(?:[-+][0-9A-Za-z.+-]*)?`,
+	"{version_code}": `\d+`,
+	"{date}":         `\d{8}`,
+}
+
+// templateRegexp matches the whole rendered template, e.g. `chore(release): 1.2.3` for `chore(release): {version_name}`,
+// with every placeholder matching any value it can be rendered with.
+func templateRegexp(template string) *regexp.Regexp {
+	pattern := ""
+	last := 0
+	for _, indexes := range templatePlaceholderRegexp.FindAllStringIndex(template, -1) {
+		placeholder := template[indexes[0]:indexes[1]]
+		value, ok := templatePlaceholderPatterns[placeholder]
+		if !ok {
+			value = `.*`
+		}
+		pattern += regexp.QuoteMeta(template[last:indexes[0]]) + value
+		last = indexes[1]
 	}
+
+	return regexp.MustCompile(`^` + pattern + regexp.QuoteMeta(template[last:]) + `$`)
 }
 
-func (configs ConfigsModel) print() {
-	log.Info("Configs:")
-	log.Detail("- BumpType: %s", configs.BumpType)
+func commitMessage(configs ConfigsModel, versions Versions) string {
+	return renderTemplate(configs.commitMessageTemplate(), versions)
 }
 
-func (configs ConfigsModel) validate() (string, error) {
-	bumpTypes := []string{"major", "minor", "patch", "none"}
-	if !sliceutil.IsStringInSlice(configs.BumpType, bumpTypes) {
-		return "", errors.New("Invalid bump type!")
+// tagName prefixes the versionName with tag_scope, e.g. `app-v1.2.3` in a monorepo.
+func tagName(configs ConfigsModel, versions Versions) string {
+	return configs.TagScope + versions.Name
+}
+
+// releaseTags returns the release tag, followed by the versionCode tag if code_tag_template is set.
+func releaseTags(configs ConfigsModel, versions Versions) []string {
+	tags := []string{}
+	if versions.Name != "" {
+		tags = append(tags, tagName(configs, versions))
+	}
+	if configs.CodeTagTemplate != "" {
+		tags = append(tags, renderTemplate(configs.CodeTagTemplate, versions))
 	}
 
-	return "", nil
+	return tags
 }
 
-func find(dir, nameInclude string) ([]string, error) {
-	cmdSlice := []string{"grep"}
-	cmdSlice = append(cmdSlice, "-l")
-	cmdSlice = append(cmdSlice, "-r", "versionCode")
-	cmdSlice = append(cmdSlice, "--include", nameInclude)
-	cmdSlice = append(cmdSlice, dir)
+// newReleaseTags returns the release tags to create, failing on, skipping or keeping already existing ones
+// to overwrite them, depending on on_existing_tag. The remote is only checked if tags are pushed.
+func newReleaseTags(configs ConfigsModel, versions Versions) ([]string, error) {
+	tags := []string{}
+	for _, tag := range releaseTags(configs, versions) {
+		where := ""
+		if _, err := gitOutput("rev-parse", "--verify", "--quiet", "refs/tags/"+tag); err == nil {
+			where = "locally"
+		} else if configs.PushTags == "true" {
+			onRemote, err := isTagOnRemote(configs.primaryRemote(), tag)
+			if err != nil {
+				return []string{}, err
+			}
+			if onRemote {
+				where = "on " + configs.primaryRemote()
+			}
+		}
 
-	log.Detail(command.PrintableCommandArgs(false, cmdSlice))
+		switch {
+		case where == "":
+			tags = append(tags, tag)
+		case configs.OnExistingTag == "skip":
+			log.Warn("Tag %s already exists %s, skipping it", tag, where)
+		case configs.OnExistingTag == "overwrite":
+			log.Warn("Tag %s already exists %s, overwriting it", tag, where)
+			tags = append(tags, tag)
+		default:
+			return []string{}, fmt.Errorf("Tag %s already exists %s, set on_existing_tag to skip or overwrite to continue", tag, where)
+		}
+	}
 
-	out, err := command.New(cmdSlice[0], cmdSlice[1:]...).RunAndReturnTrimmedOutput()
+	return tags, nil
+}
+
+var invalidTagNameRegexp = regexp.MustCompile(`[\x00-\x20\x7f~^:?*\[\\]|\.\.|@\{|//|^[/.]|[/.]$|\.lock$|^@$`)
+
+// isValidTagName follows the rules of git check-ref-format.
+func isValidTagName(name string) bool {
+	return name != "" && !invalidTagNameRegexp.MatchString(name)
+}
+
+// isValidBranchName additionally rejects names git would parse as an option.
+func isValidBranchName(name string) bool {
+	return isValidTagName(name) && !strings.HasPrefix(name, "-")
+}
+
+// checkTagOrder fails if the new versionName isn't greater than the latest existing semver tag.
+func checkTagOrder(configs ConfigsModel, versions Versions) error {
+	parser := versionParserFor(configs)
+
+	tag, latest, err := latestSemverTag(parser, configs.TagPattern, configs.TagScope)
 	if err != nil {
-		return []string{}, err
+		return err
+	}
+	if latest == nil {
+		log.Detail("No semver tag found, skipping tag order check")
+		return nil
 	}
 
-	split := strings.Split(out, "\n")
-	files := []string{}
-	for _, item := range split {
-		trimmed := strings.TrimSpace(item)
-		if trimmed != "" {
-			files = append(files, trimmed)
-		}
+	version, err := parser.Parse(versions.Name)
+	if err != nil {
+		return err
 	}
 
-	return files, nil
+	if !latest.LessThan(*version) {
+		return fmt.Errorf("new versionName %s is not greater than the latest tag %s", versions.Name, tag)
+	}
+
+	return nil
+}
+
+// writeSummaryToFile writes the plan of a single file, or module plans as an object of them keyed by the module.
+func writeSummaryToFile(file string, summaries map[string]Summary) error {
+	if summary, ok := summaries[""]; ok && len(summaries) == 1 {
+		return writeJSONFile(file, summary)
+	}
+
+	return writeJSONFile(file, summaries)
 }
 
-func getVersionsFromFile(file string) (Versions, error) {
-	bytes, err := ioutil.ReadFile(file)
+// versionsAtRef reads the versions of the files as they are at the ref, without checking it out.
+func versionsAtRef(configs ConfigsModel, ref, codeFile, nameFile string) (Versions, error) {
+	read := readVersionFile
+	readVersionFile = gitRefReader(ref)
+	defer func() { readVersionFile = read }()
+
+	return getVersionsFromFiles(configs, codeFile, nameFile)
+}
+
+// isBumpedTo tells whether the versions already are at least the target, e.g. a pull request bumped them itself.
+func isBumpedTo(configs ConfigsModel, versions, target Versions) (bool, error) {
+	if versions.Code < target.Code {
+		return false, nil
+	}
+	if target.Name == "" || versions.Name == target.Name {
+		return true, nil
+	}
+	if versions.Name == "" {
+		return false, nil
+	}
+
+	parser := versionParserFor(configs)
+	current, err := parser.Parse(versions.Name)
 	if err != nil {
-		return Versions{}, err
+		return false, err
 	}
-	re := regexp.MustCompile(`versionName\s+"([0-9.]+)"`)
-	matchesName := re.FindStringSubmatch(string(bytes))
+	bumped, err := parser.Parse(target.Name)
+	if err != nil {
+		return false, err
+	}
+
+	return !current.LessThan(*bumped), nil
+}
 
-	if len(matchesName) != 2 {
-		return Versions{}, errors.New("Failed to match `versionName`")
+// applyVersions writes the new versions, or lets version_consumer_command write them, and returns the changed files.
+func applyVersions(configs ConfigsModel, codeFile, nameFile string, versionFiles []string, old, versions Versions) ([]string, error) {
+	if configs.VersionConsumer == "" {
+		if err := setVersionsToFiles(configs, codeFile, nameFile, old, versions); err != nil {
+			return versionFiles, err
+		}
+
+		mirrors := configs.sharedVersionFiles()
+		if configs.VersionSource == "pubspec" && configs.PubspecSyncGradle != "" {
+			// the gradle file mirrors the pubspec version
+			mirrors = append(mirrors, configs.PubspecSyncGradle)
+		}
+		for _, mirror := range mirrors {
+			if err := mirrorVersionsToFile(configs, mirror, versions); err != nil {
+				return versionFiles, err
+			}
+		}
+		if configs.WriteVersionFile != "" {
+			if err := writePlainVersionFile(configs, configs.WriteVersionFile, versions); err != nil {
+				return versionFiles, err
+			}
+			mirrors = append(mirrors, configs.WriteVersionFile)
+		}
+		return append(versionFiles, mirrors...), nil
 	}
 
-	re = regexp.MustCompile(`versionCode\s+(\d+)`)
-	matchesCode := re.FindStringSubmatch(string(bytes))
+	// only the files the command changes are committed, not the ones that were already changed before
+	before, err := changedFilesSnapshot()
+	if err != nil {
+		return versionFiles, err
+	}
 
-	if len(matchesCode) != 2 {
-		return Versions{}, errors.New("Failed to match `versionCode`")
+	log.Info("Run version consumer command...")
+	cmd := command.New("sh", "-c", configs.VersionConsumer)
+	cmd.AppendEnvs(
+		"BUMP_OLD_VERSION_NAME="+old.Name,
+		"BUMP_OLD_VERSION_CODE="+strconv.Itoa(old.Code),
+		"BUMP_VERSION_NAME="+versions.Name,
+		"BUMP_VERSION_CODE="+strconv.Itoa(versions.Code),
+	)
+	cmd.SetStdout(log.Writer())
+	cmd.SetStderr(os.Stderr)
+	if exitCode, err := cmd.RunAndReturnExitCode(); err != nil {
+		return versionFiles, fmt.Errorf("version consumer command exited with %d: %s", exitCode, err)
 	}
 
-	versionCode, err := strconv.ParseInt(matchesCode[1], 10, 32)
+	root, err := gitOutput("rev-parse", "--show-toplevel")
 	if err != nil {
-		return Versions{}, err
+		return versionFiles, err
+	}
+	changed, err := filesChangedSince(before)
+	if err != nil {
+		return versionFiles, err
+	}
+	if len(changed) == 0 {
+		return versionFiles, errors.New("version consumer command changed no tracked file")
 	}
 
-	return Versions{
-		Name: matchesName[1],
-		Code: int(versionCode),
-	}, nil
+	files := []string{}
+	for _, file := range changed {
+		files = append(files, filepath.Join(root, file))
+	}
+
+	return files, nil
 }
 
-func bumpVersions(bumpType string, versions Versions) (Versions, error) {
-	versionName, err := semver.NewVersion(versions.Name)
+// mirrorVersionsToFile writes the bumped versions to a file carrying the same version, keeping its code width.
+func mirrorVersionsToFile(configs ConfigsModel, file string, versions Versions) error {
+	current, err := getVersionsFromFile(configs, file)
 	if err != nil {
-		return Versions{}, err
+		return err
 	}
 
-	switch bumpType {
-	case "major":
-		versionName.BumpMajor()
-	case "minor":
-		versionName.BumpMinor()
-	case "patch":
-		versionName.BumpPatch()
-	default:
+	mirrored := versions
+	mirrored.FlavorCodes = nil
+	mirrored.codeWidth = current.codeWidth
+
+	return setVersionsToFile(configs, file, current, mirrored)
+}
+
+// writePlainVersionFile writes the versionName, and the versionCode on a second line with version_file_code,
+// to a plain file, e.g. a top-level VERSION read by other tooling.
+func writePlainVersionFile(configs ConfigsModel, file string, versions Versions) error {
+	if versions.Name == "" {
+		return fmt.Errorf("No versionName to write to %s", file)
 	}
 
-	return Versions{
-		Name: versionName.String(),
-		Code: versions.Code + 1,
-	}, nil
+	name := versions.Name
+	if configs.StripMetadataOnWrite == "true" {
+		name = strings.SplitN(name, "+", 2)[0]
+	}
+	content := name + "\n"
+	if configs.VersionFileCode == "true" {
+		content += strconv.Itoa(versions.Code) + "\n"
+	}
+
+	return ioutil.WriteFile(file, []byte(content), 0644)
 }
 
-func setVersionsToFile(file string, versions Versions) error {
-	bytes, err := ioutil.ReadFile(file)
+// checkWritable checks an existing file can be opened for writing, or a new one created in its directory.
+func checkWritable(file string) error {
+	if info, err := os.Stat(file); err == nil {
+		if info.IsDir() {
+			return errors.New("it is a directory")
+		}
+		handle, err := os.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return handle.Close()
+	}
+
+	probe, err := ioutil.TempFile(filepath.Dir(file), ".bump-probe")
 	if err != nil {
 		return err
 	}
+	probe.Close()
+
+	return os.Remove(probe.Name())
+}
+
+// rebaseBump drops the bump commit, rebases onto the updated remote branch and bumps again,
+// as the remote may have moved the versions in the meantime.
+func rebaseBump(configs ConfigsModel, codeFile, nameFile string, versionFiles []string) (Versions, Versions, error) {
+	branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return Versions{}, Versions{}, err
+	}
 
-	re := regexp.MustCompile(`versionName\s+"([0-9.]+)"`)
-	body := re.ReplaceAllString(string(bytes), "versionName \"" + versions.Name + "\"")
+	remote := configs.primaryRemote()
+	if err := gitCommand("fetch", remote, branch); err != nil {
+		return Versions{}, Versions{}, err
+	}
+	if err := gitCommand("reset", "--keep", "HEAD~1"); err != nil {
+		return Versions{}, Versions{}, err
+	}
+	if err := gitCommand("rebase", "--autostash", remote+"/"+branch); err != nil {
+		if abortErr := gitCommand("rebase", "--abort"); abortErr != nil {
+			log.Warn("Failed to abort rebase: %s", abortErr)
+		}
+		return Versions{}, Versions{}, err
+	}
 
-	re = regexp.MustCompile(`versionCode\s+(\d+)`)
-	body = re.ReplaceAllString(body, "versionCode " + strconv.Itoa(versions.Code))
+	versions, err := getVersionsFromFiles(configs, codeFile, nameFile)
+	if err != nil {
+		return Versions{}, Versions{}, err
+	}
+	newVersions, err := bumpVersions(configs, versions)
+	if err != nil {
+		return Versions{}, Versions{}, err
+	}
 
-	ioutil.WriteFile(file, []byte(body), 0644)
+	if versionFiles, err = applyVersions(configs, codeFile, nameFile, versionFiles, versions, newVersions); err != nil {
+		return Versions{}, Versions{}, err
+	}
+	if err := gitCommand(append([]string{"add", "--"}, versionFiles...)...); err != nil {
+		return Versions{}, Versions{}, err
+	}
+	if err := gitCommandWithEnvs(gitCommitEnvs(configs), gitCommitArgs(configs, newVersions)...); err != nil {
+		return Versions{}, Versions{}, err
+	}
 
-	return nil
+	return versions, newVersions, nil
 }
 
-func exportEnvironmentWithEnvman(key, value string) error {
-	cmd := command.New("envman", "add", "--key", key)
-	cmd.SetStdin(strings.NewReader(value))
-	return cmd.Run()
+// moduleBump is the bump of one build.gradle file, computed for every file before any of them is committed.
+type moduleBump struct {
+	File         string
+	Module       string
+	Configs      ConfigsModel
+	CodeFile     string
+	NameFile     string
+	VersionFiles []string
+	Old          Versions
+	New          Versions
+	Tags         []string
 }
 
-func gitCommand(args ...string) error {
-	cmd := command.New("git", args...)
-	cmd.SetStdout(os.Stdout)
-	cmd.SetStderr(os.Stderr)
-	return cmd.Run()
+// failInput logs an issue with the inputs and exits.
+func failInput(explanation string, err error) {
+	log.Newline()
+	log.Error("Issue with input: %s", err)
+	log.Newline()
+
+	if explanation != "" {
+		log.Plain("%s", explanation)
+		log.Newline()
+	}
+
+	os.Exit(1)
 }
 
 func main() {
-	configs := createConfigsModelFromEnvs()
+	// config_file errors are logged in the format of the environment, it may set log_format itself
+	log.SetFormat(os.Getenv("log_format"))
+	configs, err := createConfigsModel()
+	if err != nil {
+		log.Fail("Failed to read config_file: %s", err)
+	}
+	log.SetFormat(configs.LogFormat)
 	configs.print()
 	if explanation, err := configs.validate(); err != nil {
-		fmt.Println()
-		log.Error("Issue with input: %s", err)
-		fmt.Println()
+		failInput(explanation, err)
+	}
+	if value, ok := os.LookupEnv("do_push_tags"); ok {
+		log.Warn("do_push_tags (%s) is not an input of the step and is ignored, set push_tags instead", value)
+	}
+
+	// before anything reads the repository, the doctor only reports whether the branch can be checked out
+	if configs.CheckoutBranch != "" && configs.Mode != "doctor" {
+		if err := checkoutBranch(configs, configs.CheckoutBranch); err != nil {
+			log.Fail("Failed to check out %s: %s", configs.CheckoutBranch, err)
+		}
+	}
+	if explanation, err := configs.validateFiles(); err != nil {
+		failInput(explanation, err)
+	}
+
+	if configs.BumpType == "from-commit" {
+		bumpType, err := bumpTypeFromCommit(configs.BumpTrailer)
+		if err != nil {
+			log.Fail("Failed to read bump type from the last commit: %s", err)
+		}
+		if bumpType == "" {
+			log.Detail("No %s trailer in the last commit, bumping %s", configs.BumpTrailer, configs.BumpTrailerDefault)
+			bumpType = configs.BumpTrailerDefault
+		} else if !sliceutil.IsStringInSlice(bumpType, bumpTypes) {
+			log.Fail("%s trailer of the last commit is %s, it must be one of: %s", configs.BumpTrailer, bumpType, strings.Join(bumpTypes, ", "))
+		} else {
+			log.Detail("Bump type from the %s trailer of the last commit: %s", configs.BumpTrailer, bumpType)
+		}
+		configs.BumpType = bumpType
+	}
+
+	if configs.Mode == "doctor" {
+		if !runDoctor(configs) {
+			log.Fail("Doctor found problems with the configured flow")
+		}
+		log.Done("Everything the configured flow needs is in place")
+		return
+	}
+
+	if configs.Mode == "bump" {
+		if configs.GitConfigScope == "local" {
+			restore, err := configureLocalIdentity(configs)
+			if err != nil {
+				log.Fail("Failed to configure git identity: %s", err)
+			}
+			if configs.RestoreConfig == "true" {
+				log.OnFail(restore)
+				defer restore()
+			}
+		}
+
+		if err := attachDetachedHead(configs); err != nil {
+			log.Fail("Failed to prepare branch: %s", err)
+		}
+
+		branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			log.Fail("Failed to read current branch: %s", err)
+		}
+		if !configs.isBranchAllowed(branch) {
+			log.Fail("Branch %s is not in allowed_branches (%s), refusing to bump", branch, configs.AllowedBranches)
+		}
+
+		if configs.pushes() {
+			for _, remote := range configs.gitRemotes() {
+				if _, err := gitOutput("remote", "get-url", remote); err != nil {
+					log.Fail("Remote %s of git_remotes does not exist: %s", remote, err)
+				}
+			}
+		}
+
+		if configs.DoTag == "true" && configs.TagScope != "" {
+			collisions, err := scopedTagCollisions(versionParserFor(configs), configs.TagScope)
+			if err != nil {
+				log.Fail("Failed to list tags: %s", err)
+			}
+			if len(collisions) > 0 {
+				log.Fail("Tags %s start with tag_scope %s but aren't its versions, the scope overlaps another tag namespace", strings.Join(collisions, ", "), configs.TagScope)
+			}
+		}
+
+		if configs.DoMerge == "true" {
+			if err := ensureFullHistory(configs); err != nil {
+				log.Fail("Failed to prepare history: %s", err)
+			}
+		}
 
-		if explanation != "" {
-			fmt.Println(explanation)
-			fmt.Println()
+		if configs.Amend == "true" {
+			pushed, err := isHeadPushed()
+			if err != nil {
+				log.Fail("Failed to check if HEAD is pushed: %s", err)
+			}
+
+			if pushed && configs.ForceWithLease != "true" {
+				log.Fail("HEAD is already pushed, amending it would rewrite published history, set force_with_lease to true to allow it")
+			}
+		}
+
+		if configs.SkipIfLastCommitIsBump == "true" {
+			isBump, err := isLastCommitBump(configs)
+			if err != nil {
+				log.Fail("Failed to read last commit: %s", err)
+			}
+
+			if isBump {
+				log.Done("Last commit is a version bump, skipping")
+				return
+			}
 		}
+	}
 
-		os.Exit(1)
+	if configs.ListMatches == "true" {
+		log.Info("List matching files...")
+		if err := listMatches(configs); err != nil {
+			log.Fail("Failed to list matching files: %s", err)
+		}
 	}
 
 	log.Info("Find build.gradle file...")
-	buildGradleFiles, err := find(".", "build.gradle")
+	buildGradleFiles, err := findBuildGradleFiles(configs)
+	if errors.Is(err, ErrFileNotFound) && configs.MissingFileBehavior == "skip" {
+		log.Warn("No `build.gradle` file found, skipping the bump")
+		return
+	}
 	if err != nil {
-		log.Fail("Failed to find `build.gradle` file: %s", err)
+		failWithHint(err, "Failed to find `build.gradle` file: %s", err)
+	}
+
+	if configs.Mode == "bump" && configs.RequireCleanTree == "true" {
+		changed, err := changedFilesExcept(configs.targetFiles(buildGradleFiles))
+		if err != nil {
+			log.Fail("Failed to check working tree: %s", err)
+		}
+
+		if len(changed) > 0 {
+			log.Error("Working tree has uncommitted changes:")
+			for _, file := range changed {
+				log.Detail("%s", file)
+			}
+			log.Fail("Commit or stash them before bumping, or set require_clean_tree to false")
+		}
+	}
+
+	readRef := ""
+	if (configs.Mode == "read_ref" || configs.Mode == "fail_if_bump_needed") && configs.ReadRef != "" {
+		readRef, err = gitOutput("rev-parse", "--verify", "--quiet", configs.ReadRef+"^{commit}")
+		if err != nil {
+			log.Fail("Ref %s does not exist", configs.ReadRef)
+		}
 	}
 
-	if len(buildGradleFiles) == 0 {
-		log.Fail("No `build.gradle` file found")
+	if configs.Mode == "read_ref" {
+		for _, buildGradleFile := range buildGradleFiles {
+			configs, _ := configs.forBuildGradleFile(buildGradleFile)
+			codeFile, nameFile := configs.versionFiles(buildGradleFile)
+			versions, err := versionsAtRef(configs, readRef, codeFile, nameFile)
+			if err != nil {
+				failWithHint(err, "Failed to read versions of %s at %s: %s", buildGradleFile, configs.ReadRef, err)
+			}
+			log.Done("%s at %s: versionName %s, versionCode %d", buildGradleFile, configs.ReadRef, valueOrNone(versions.Name), versions.Code)
+		}
+		return
 	}
 
-	if len(buildGradleFiles) != 1 {
-		log.Fail("Found more than one `build.gradle` file")
+	if configs.Mode == "inspect" {
+		for _, buildGradleFile := range buildGradleFiles {
+			configs, _ := configs.forBuildGradleFile(buildGradleFile)
+			codeFile, nameFile := configs.versionFiles(buildGradleFile)
+			log.Info("Matches in %s:", buildGradleFile)
+			inspectVersionFiles(configs, codeFile, nameFile)
+		}
+		log.Done("Inspect mode, no changes made")
+		return
 	}
 
+	bumpNeeded := []string{}
+	results := []runResult{}
+	plans := map[string]Summary{}
+	emitted := map[string]Versions{}
+	bumps := []moduleBump{}
 	for _, buildGradleFile := range buildGradleFiles {
+		configs, module := configs.forBuildGradleFile(buildGradleFile)
+		if module != "" {
+			log.Info("Module %s (bump type %s):", module, configs.BumpType)
+		}
+
 		log.Info("Current versions:")
 
-		versions, err := getVersionsFromFile(buildGradleFile)
+		codeFile, nameFile := configs.versionFiles(buildGradleFile)
+
+		versions, err := getVersionsFromFiles(configs, codeFile, nameFile)
+		if err != nil {
+			failWithHint(err, "Failed to get versions: %s", err)
+		}
+
+		versionFiles, err := versionFieldFiles(configs, codeFile, nameFile)
+		if err != nil {
+			failWithHint(err, "Failed to get versions: %s", err)
+		}
+
+		offsets, err := configs.flavorOffsets()
 		if err != nil {
-			log.Fail("Failed to get versions: %s", err)
+			log.Fail("Failed to parse flavor offsets: %s", err)
+		}
+		for flavor := range offsets {
+			if _, err := locateFlavorVersionCode(codeFile, flavor); err != nil {
+				log.Fail("Failed to find flavor versionCode: %s", err)
+			}
 		}
 		log.Detail("versionCode: %d", versions.Code)
-		log.Detail("versionName: %s", versions.Name)
+		if configs.ExtraCodeField != "" {
+			log.Detail("%s: %d", configs.ExtraCodeField, versions.ExtraCode)
+		}
+		if versions.Name == "" {
+			log.Detail("versionName: not found, only versionCode is bumped")
+		} else {
+			log.Detail("versionName: %s", versions.Name)
+		}
+
+		if err := checkSharedVersions(configs, buildGradleFile, versions); err != nil {
+			log.Fail("Failed to check shared version files: %s", err)
+		}
+
+		if configs.RemoteVersionURL != "" && versions.Name != "" {
+			ahead, err := isAheadOfRemote(configs, versions)
+			if err != nil {
+				log.Fail("Failed to compare with remote version: %s", err)
+			}
+			if ahead {
+				log.Done("Local versionName is ahead of the remote one, skipping")
+				continue
+			}
+		}
+
+		if configs.SkipIfAtTarget == "true" && versions.Name != "" {
+			atTarget, err := configs.isAtExplicitVersion(versions.Name)
+			if err != nil {
+				log.Fail("Failed to compare with the set version: %s", err)
+			}
+			if atTarget {
+				log.Done("versionName %s already is the set version, skipping", versions.Name)
+				continue
+			}
+		}
+
+		baseVersions := versions
+		if configs.VersionFromTag == "true" && versions.Name != "" {
+			tag, latest, err := latestSemverTag(versionParserFor(configs), configs.TagPattern, configs.TagScope)
+			if err != nil {
+				log.Fail("Failed to find latest tag: %s", err)
+			}
+			if latest == nil {
+				log.Detail("No semver tag found, bumping the versionName of the file")
+			} else {
+				log.Detail("versionName from tag: %s", tag)
+				baseVersions.Name = strings.TrimPrefix(tag, configs.TagScope)
+			}
+		}
+
+		if configs.VersionFromDescribe == "true" && versions.Name != "" {
+			// only the release tags of the scope are versions, code tags and other scopes' tags are skipped
+			exclude := ""
+			if configs.CodeTagTemplate != "" {
+				exclude = templateGlob(configs.CodeTagTemplate)
+			}
+			matches := []string{configs.TagScope + configs.DescribePrefix + "[0-9]*", configs.TagScope + "[0-9]*"}
+			tag, err := describeTag(matches, exclude)
+			if err != nil {
+				log.Fail("Failed to describe HEAD: %s", err)
+			}
+			if tag == "" && configs.DescribeFallback == "fail" {
+				log.Fail("No tag is reachable from HEAD to read the versionName from")
+			} else if tag == "" {
+				log.Detail("No tag reachable from HEAD, bumping the versionName of the file")
+			} else {
+				name := strings.TrimPrefix(strings.TrimPrefix(tag, configs.TagScope), configs.DescribePrefix)
+				if _, err := versionParserFor(configs).Parse(name); err != nil {
+					log.Fail("Tag %s described from HEAD is not a version: %s", tag, err)
+				}
+				log.Detail("versionName from git describe: %s", name)
+				baseVersions.Name = name
+			}
+		}
 
-		newVersions, err := bumpVersions(configs.BumpType, versions)
+		newVersions, err := bumpVersions(configs, baseVersions)
 		if err != nil {
 			log.Fail("Failed to bump versions: %s", err)
 		}
 
-
 		log.Info("New versions:")
 		log.Detail("versionCode: %d", newVersions.Code)
-		log.Detail("versionName: %s", newVersions.Name)
+		if newVersions.Name != "" {
+			log.Detail("versionName: %s", newVersions.Name)
+		}
+		if configs.ExtraCodeField != "" {
+			log.Detail("%s: %d", configs.ExtraCodeField, newVersions.ExtraCode)
+		}
+		for _, flavor := range sortedFlavors(newVersions.FlavorCodes) {
+			log.Detail("versionCode (%s): %d", flavor, newVersions.FlavorCodes[flavor])
+		}
+
+		if configs.CheckTagOrder == "true" && newVersions.Name != "" {
+			if err := checkTagOrder(configs, newVersions); err != nil {
+				log.Fail("Failed to check tag order: %s", err)
+			}
+		}
+
+		if configs.RequireCoupledBump == "true" {
+			if err := checkCoupledBump(versions, newVersions); err != nil {
+				log.Fail("Failed to bump %s: %s", buildGradleFile, err)
+			}
+		}
+
+		if configs.Mode == "fail_if_bump_needed" {
+			// the file needs a bump unless it already is at least the bump of the reference
+			target := newVersions
+			if readRef != "" {
+				refVersions, err := versionsAtRef(configs, readRef, codeFile, nameFile)
+				if err != nil {
+					failWithHint(err, "Failed to read versions of %s at %s: %s", buildGradleFile, configs.ReadRef, err)
+				}
+				log.Detail("%s at %s: versionName %s, versionCode %d", buildGradleFile, configs.ReadRef, valueOrNone(refVersions.Name), refVersions.Code)
+				if target, err = bumpVersions(configs, refVersions); err != nil {
+					log.Fail("Failed to bump versions of %s: %s", configs.ReadRef, err)
+				}
+			}
+
+			bumped, err := isBumpedTo(configs, versions, target)
+			if err != nil {
+				log.Fail("Failed to compare versions: %s", err)
+			}
+			if bumped {
+				log.Done("%s is up to date", buildGradleFile)
+				continue
+			}
+
+			log.Warn("%s would change:", buildGradleFile)
+			if versions.Code < target.Code {
+				log.Detail("versionCode: %d -> %d", versions.Code, target.Code)
+			}
+			if versions.Name != target.Name {
+				log.Detail("versionName: %s -> %s", versions.Name, target.Name)
+			}
+			bumpNeeded = append(bumpNeeded, buildGradleFile)
+			continue
+		}
+
+		if configs.Mode == "plan" {
+			summary := Summary{
+				File:          buildGradleFile,
+				Old:           versions,
+				New:           newVersions,
+				Tag:           tagName(configs, newVersions),
+				CommitMessage: commitMessage(configs, newVersions),
+			}
+
+			log.Info("Plan:")
+			log.Detail("file: %s", summary.File)
+			log.Detail("tag: %s", summary.Tag)
+			log.Detail("commit message: %s", summary.CommitMessage)
+
+			plans[module] = summary
+
+			log.Done("Plan mode, no changes made")
+			continue
+		}
+
+		emitVersions(versionEmitters(configs, module, versions, emitted), newVersions)
+
+		if configs.Mode == "export_only" {
+			log.Done("Export only mode, %s left unchanged", buildGradleFile)
+			continue
+		}
+
+		bumps = append(bumps, moduleBump{
+			File:         buildGradleFile,
+			Module:       module,
+			Configs:      configs,
+			CodeFile:     codeFile,
+			NameFile:     nameFile,
+			VersionFiles: versionFiles,
+			Old:          versions,
+			New:          newVersions,
+			Tags:         []string{},
+		})
+	}
+
+	// every module's tags are checked before the first one is committed, an existing tag of a later
+	// module would otherwise fail the run with the earlier modules already pushed
+	for i, bump := range bumps {
+		if bump.Configs.DoTag != "true" {
+			continue
+		}
+		tags, err := newReleaseTags(bump.Configs, bump.New)
+		if err != nil {
+			log.Fail("Failed to check existing tags of %s: %s", bump.File, err)
+		}
+		bumps[i].Tags = tags
+	}
 
-		if err := exportEnvironmentWithEnvman("BUMP_VERSION_CODE", strconv.Itoa(newVersions.Code)); err != nil {
-			log.Fail("Failed to export enviroment (BUMP_VERSION_CODE): %s", err)
+	for _, bump := range bumps {
+		configs, module, buildGradleFile := bump.Configs, bump.Module, bump.File
+		codeFile, nameFile, versionFiles := bump.CodeFile, bump.NameFile, bump.VersionFiles
+		versions, newVersions, tags := bump.Old, bump.New, bump.Tags
+		if module != "" {
+			log.Info("Releasing module %s:", module)
 		}
-		if err := exportEnvironmentWithEnvman("BUMP_VERSION_NAME", newVersions.Name); err != nil {
-			log.Fail("Failed to export enviroment (BUMP_VERSION_CODE): %s", err)
+		// the tags and the merge branch are resolved with these, a rebase of the bump may change them
+		resolvedVersions := newVersions
+
+		branch := ""
+		if configs.DoMerge == "true" {
+			branch, err = mergeBranch(configs, newVersions)
+			if err != nil {
+				log.Fail("Failed to resolve merge branch: %s", err)
+			}
 		}
 
-		if err := setVersionsToFile(buildGradleFile, newVersions); err != nil {
-			log.Fail("Failed to export enviroment (BUMP_VERSION_CODE): %s", err)
+		versionFiles, err = applyVersions(configs, codeFile, nameFile, versionFiles, versions, newVersions)
+		if err != nil {
+			failWithHint(err, "Failed to write versions to %s: %s", strings.Join(versionFiles, ", "), err)
 		}
 
 		log.Info("Git diff:")
-		if err := gitCommand("diff", buildGradleFile); err != nil {
+		if err := gitCommand(append([]string{"diff", "--"}, versionFiles...)...); err != nil {
 			log.Fail("Failed to git diff: %s", err)
 		}
 
-		if err := gitCommand("add", buildGradleFile); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		commitSHA := ""
+		if configs.DoCommit == "true" {
+			if err := gitCommand(append([]string{"add", "--"}, versionFiles...)...); err != nil {
+				log.Fail("Failed to git add: %s", err)
+			}
+
+			if configs.ExportDiffPath != "" {
+				if err := exportStagedDiff(configs.ExportDiffPath, versionFiles); err != nil {
+					log.Fail("Failed to export diff to %s: %s", configs.ExportDiffPath, err)
+				}
+				log.Detail("diff exported to: %s", configs.ExportDiffPath)
+			}
+
+			if err := gitCommandWithEnvs(gitCommitEnvs(configs), gitCommitArgs(configs, newVersions)...); err != nil {
+				log.Fail("Failed to git commit: %s", err)
+			}
+
+			commitSHA, err = gitOutput("rev-parse", "HEAD")
+			if err != nil {
+				log.Fail("Failed to get commit SHA: %s", err)
+			}
+			exportModuleOutput(configs, module, "BUMP_COMMIT_SHA", commitSHA)
 		}
 
-		if err := gitCommand("commit", "-m", "Bump version to " + newVersions.Name); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		if configs.DoPushBranch == "true" {
+			currentBranch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+			if err != nil {
+				log.Fail("Failed to read current branch: %s", err)
+			}
+
+			retries, _ := strconv.Atoi(configs.ReleaseRetries)
+			for attempt := 1; ; attempt++ {
+				err := gitCommand(gitPushBranchArgs(configs, configs.primaryRemote(), currentBranch)...)
+				if err == nil {
+					break
+				}
+				if attempt > retries {
+					log.Fail("Failed to git push: %s", err)
+				}
+
+				log.Warn("Failed to git push, rebasing the bump onto the remote branch (retry %d/%d): %s", attempt, retries, err)
+				versions, newVersions, err = rebaseBump(configs, codeFile, nameFile, versionFiles)
+				if err != nil {
+					log.Fail("Failed to rebase the bump: %s", err)
+				}
+				log.Detail("rebased bump: %s (%d) -> %s (%d)", versions.Name, versions.Code, newVersions.Name, newVersions.Code)
+
+				emitVersions(versionEmitters(configs, module, versions, emitted), newVersions)
+				commitSHA, err = gitOutput("rev-parse", "HEAD")
+				if err != nil {
+					log.Fail("Failed to get commit SHA: %s", err)
+				}
+				exportModuleOutput(configs, module, "BUMP_COMMIT_SHA", commitSHA)
+			}
+
+			mirrors := configs.gitRemotes()[1:]
+			if err := pushToRemotes(mirrors, func(remote string) []string {
+				return gitPushBranchArgs(configs, remote, currentBranch)
+			}); err != nil {
+				log.Fail("Failed to git push: %s", err)
+			}
 		}
 
-		if err := gitCommand("push", "origin", "HEAD"); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		rebased := resolvedVersions.Name != newVersions.Name || resolvedVersions.Code != newVersions.Code
+
+		if configs.DoMerge == "true" {
+			if rebased {
+				// the rebased bump has other versions than the merge branch was resolved with,
+				// the merge message is rendered with the rebased versions below
+				branch, err = mergeBranch(configs, newVersions)
+				if err != nil {
+					log.Fail("Failed to resolve merge branch: %s", err)
+				}
+			}
+			if err := gitCommand("checkout", "master"); err != nil {
+				log.Fail("Failed to git checkout: %s", err)
+			}
+
+			if err := gitCommand(gitMergeArgs(configs, branch, newVersions)...); err != nil {
+				log.Fail("Failed to git merge: %s", err)
+			}
 		}
 
-		if err := gitCommand("checkout", "master"); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		if configs.DoTag == "true" {
+			if rebased {
+				// the rebased bump has other versions than the ones checked before committing
+				tags, err = newReleaseTags(configs, newVersions)
+				if err != nil {
+					log.Fail("Failed to check existing tags: %s", err)
+				}
+			}
+			for _, tag := range tags {
+				if err := gitCommand(gitTagArgs(configs, tag)...); err != nil {
+					log.Fail("Failed to git tag: %s", err)
+				}
+				if err := verifyTag(tag); err != nil {
+					log.Fail("Failed to verify tag %s, not pushing: %s", tag, err)
+				}
+			}
+			if newVersions.Name != "" {
+				exportModuleOutput(configs, module, "BUMP_TAG_NAME", tagName(configs, newVersions))
+			}
+			if configs.CodeTagTemplate != "" {
+				exportModuleOutput(configs, module, "BUMP_CODE_TAG_NAME", renderTemplate(configs.CodeTagTemplate, newVersions))
+			}
+
+			if configs.PushTags != "true" && len(tags) > 0 {
+				log.Warn("Tags %s created locally, but not pushed", strings.Join(tags, ", "))
+			}
 		}
 
-		if err := gitCommand("merge", "develop"); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		if configs.DoMerge == "true" {
+			if len(tags) > 0 && configs.PushTags == "true" && configs.tagPushDelay() > 0 {
+				if err := pushToRemotes(configs.gitRemotes(), func(remote string) []string {
+					return gitPushReleaseArgs(configs, remote, []string{})
+				}); err != nil {
+					log.Fail("Failed to git push: %s", err)
+				}
+				waitForRemoteHead(configs.primaryRemote(), configs.tagPushDelay())
+				if err := pushToRemotes(configs.gitRemotes(), func(remote string) []string {
+					return gitPushTagsArgs(configs, remote, tags)
+				}); err != nil {
+					log.Fail("Failed to git push tag: %s", err)
+				}
+			} else if err := pushToRemotes(configs.gitRemotes(), func(remote string) []string {
+				return gitPushReleaseArgs(configs, remote, tags)
+			}); err != nil {
+				log.Fail("Failed to git push: %s", err)
+			} else if configs.PushTags == "true" && configs.TagPushMode == "follow-tags" {
+				for _, tag := range tags {
+					pushed, err := isTagOnRemote(configs.primaryRemote(), tag)
+					if err != nil {
+						log.Warn("Failed to check if tag %s was pushed: %s", tag, err)
+					} else if !pushed {
+						log.Warn("Tag %s was not pushed by --follow-tags, set tag_push_mode to explicit to push it by name", tag)
+					} else {
+						log.Detail("Tag %s pushed", tag)
+					}
+				}
+			}
+		} else if len(tags) > 0 && configs.PushTags == "true" {
+			if configs.DoPushBranch == "true" && configs.tagPushDelay() > 0 {
+				waitForRemoteHead(configs.primaryRemote(), configs.tagPushDelay())
+			}
+			if err := pushToRemotes(configs.gitRemotes(), func(remote string) []string {
+				return gitPushTagsArgs(configs, remote, tags)
+			}); err != nil {
+				log.Fail("Failed to git push tag: %s", err)
+			}
 		}
 
-		if err := gitCommand("tag", "-a", newVersions.Name, "-m", newVersions.Name); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		if configs.PostBumpMergeBack == "true" {
+			target, err := mergeBackBranch(configs, newVersions)
+			if err != nil {
+				log.Fail("Failed to resolve merge back branch: %s", err)
+			}
+
+			if err := mergeBack(target, configs.gitRemotes()); err != nil {
+				log.Fail("Failed to merge back: %s", err)
+			}
+			log.Done("Merged the bump back into %s", target)
 		}
 
-		if err := gitCommand("push", "origin", "HEAD", "--follow-tags"); err != nil {
-			log.Fail("Failed to git diff: %s", err)
+		if configs.DoMerge == "true" && module != "" {
+			// the next module is bumped on the merged branch again, not on master
+			if err := gitCommand("checkout", branch); err != nil {
+				log.Fail("Failed to git checkout: %s", err)
+			}
 		}
+
+		results = append(results, runResult{
+			File:         buildGradleFile,
+			Module:       module,
+			Old:          versions,
+			New:          newVersions,
+			CommitSHA:    commitSHA,
+			Tags:         tags,
+			PushedBranch: configs.DoPushBranch == "true",
+			PushedTags:   configs.PushTags == "true" && len(tags) > 0,
+		})
+	}
+
+	if len(results) > 0 {
+		printRunSummary(results)
+	}
+
+	if configs.PlanOutputPath != "" && len(plans) > 0 {
+		if err := writeSummaryToFile(configs.PlanOutputPath, plans); err != nil {
+			log.Fail("Failed to write plan to %s: %s", configs.PlanOutputPath, err)
+		}
+		log.Detail("Plan written to: %s", configs.PlanOutputPath)
+	}
+
+	if len(bumpNeeded) > 0 {
+		log.Fail("A bump is needed for: %s", strings.Join(bumpNeeded, ", "))
 	}
 }
@@ -0,0 +1,1031 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stepDefaults are the default values of the step.yml inputs, read before any test changes the directory.
+var stepDefaults map[string]string
+
+func TestMain(m *testing.M) {
+	// runStep runs the test binary as the step
+	if os.Getenv("BUMP_TEST_RUN_STEP") == "1" {
+		main()
+		os.Exit(0)
+	}
+
+	defaults, err := readStepInputDefaults("step.yml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read step.yml: %s\n", err)
+		os.Exit(1)
+	}
+	stepDefaults = defaults
+
+	os.Exit(m.Run())
+}
+
+var stepInputRegexp = regexp.MustCompile(`^  - ([a-z_]+):\s*(.*)$`)
+
+// readStepInputDefaults reads the `- input: default` lines of the inputs of a step.yml.
+func readStepInputDefaults(file string) (map[string]string, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := map[string]string{}
+	inInputs := false
+	for _, line := range strings.Split(string(bytes), "\n") {
+		switch {
+		case line == "inputs:":
+			inInputs = true
+			continue
+		case line == "outputs:":
+			inInputs = false
+		}
+		if !inInputs {
+			continue
+		}
+
+		matches := stepInputRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		value, err := unquoteYAMLScalar(matches[2])
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %s", matches[1], err)
+		}
+		defaults[matches[1]] = value
+	}
+
+	return defaults, nil
+}
+
+// testConfigs returns the configs of the step.yml defaults with bump type patch, changed by the overrides.
+func testConfigs(t *testing.T, overrides map[string]string) ConfigsModel {
+	t.Helper()
+
+	for key, value := range stepDefaults {
+		t.Setenv(key, value)
+	}
+	t.Setenv("bump_type", "patch")
+	// outputs of the tests must not end up in the GitHub Actions job running them
+	t.Setenv("GITHUB_OUTPUT", "")
+	for key, value := range overrides {
+		t.Setenv(key, value)
+	}
+
+	configs, err := createConfigsModel()
+	if err != nil {
+		t.Fatalf("createConfigsModel: %s", err)
+	}
+
+	return configs
+}
+
+// runStep runs the step in the working directory with the inputs of testConfigs and returns its output.
+func runStep(t *testing.T, overrides map[string]string) (string, error) {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(executable)
+	// a later value of a duplicate key wins
+	cmd.Env = append(os.Environ(), "BUMP_TEST_RUN_STEP=1", "GITHUB_OUTPUT=", "bump_type=patch")
+	for key, value := range stepDefaults {
+		if _, ok := overrides[key]; !ok && key != "bump_type" {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	for key, value := range overrides {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeFixture writes the file, creating its directories.
+func writeFixture(t *testing.T, file, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFixture(t *testing.T, file string) string {
+	t.Helper()
+
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bytes)
+}
+
+const buildGradleFixture = `android {
+    defaultConfig {
+        versionCode 12
+        versionName "1.2.3"
+    }
+}
+`
+
+func TestBuildMetadata(t *testing.T) {
+	tests := []struct {
+		env    string
+		prefix string
+		value  string
+		want   string
+	}{
+		{"", "", "", ""},
+		{"BUMP_TEST_BUILD", "", "457", "457"},
+		{"BUMP_TEST_BUILD", "build.", "457", "build.457"},
+		{"BUMP_TEST_BUILD", "build.", "", "build."},
+	}
+
+	for _, test := range tests {
+		t.Setenv("BUMP_TEST_BUILD", test.value)
+		configs := testConfigs(t, map[string]string{"build_metadata_env": test.env, "build_metadata_prefix": test.prefix})
+		if metadata := configs.buildMetadata(); metadata != test.want {
+			t.Errorf("buildMetadata() of %s=%q with prefix %q = %q, want %q", test.env, test.value, test.prefix, metadata, test.want)
+		}
+	}
+}
+
+func TestBumpVersionNameBuildMetadata(t *testing.T) {
+	t.Setenv("BUMP_TEST_BUILD", "457")
+	configs := testConfigs(t, map[string]string{"build_metadata_env": "BUMP_TEST_BUILD", "build_metadata_prefix": "build."})
+
+	for name, want := range map[string]string{"1.2.3": "1.2.4+build.457", "1.2.3+build.450": "1.2.4+build.457"} {
+		if bumped, err := bumpVersionName(configs, name); err != nil || bumped != want {
+			t.Errorf("bumpVersionName(%s) = %s, %v, want %s", name, bumped, err, want)
+		}
+	}
+}
+
+func TestIsValidSemverIdentifiers(t *testing.T) {
+	tests := map[string]bool{
+		"457":          true,
+		"build.457":    true,
+		"sha-1a2b3c":   true,
+		"build..457":   false,
+		"build.":       false,
+		"":             false,
+		"build_457":    false,
+		"build+457":    false,
+		"build 457":    false,
+		"exp.sha.5114": true,
+	}
+
+	for identifiers, want := range tests {
+		if valid := isValidSemverIdentifiers(identifiers); valid != want {
+			t.Errorf("isValidSemverIdentifiers(%q) = %v, want %v", identifiers, valid, want)
+		}
+	}
+}
+
+func TestHintFor(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		hint string
+	}{
+		{ErrFileNotFound, "set gradle_file_path"},
+		{ErrMultipleFiles, "set module (e.g. app) or gradle_file_path"},
+		{ErrVersionNameNotFound, `versionName "X.Y.Z"`},
+		{ErrVersionCodeNotFound, "versionCode N"},
+		{fmt.Errorf("app/build.gradle: %w", ErrVersionCodeNotFound), "versionCode N"},
+		{ErrFileNotWritable, "make_writable"},
+		{errors.New("unknown"), ""},
+	} {
+		hint := hintFor(test.err)
+		if test.hint == "" && hint != "" {
+			t.Errorf("hintFor(%q) = %q, want no hint", test.err, hint)
+		}
+		if !strings.Contains(hint, test.hint) {
+			t.Errorf("hintFor(%q) = %q, want it to contain %q", test.err, hint, test.hint)
+		}
+	}
+}
+
+func TestFindBuildGradleFilesErrorsHaveHints(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		files     []string
+		overrides map[string]string
+		err       error
+	}{
+		{"no file", []string{}, nil, ErrFileNotFound},
+		{"two files", []string{"app/build.gradle", "wear/build.gradle"}, nil, ErrMultipleFiles},
+		{"missing gradle_file_path", []string{"app/build.gradle"}, map[string]string{"gradle_file_path": "mobile/build.gradle"}, ErrGradleFileNotExist},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			for _, file := range test.files {
+				writeFixture(t, file, buildGradleFixture)
+			}
+
+			_, err := findBuildGradleFiles(testConfigs(t, test.overrides))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("findBuildGradleFiles() error = %v, want %v", err, test.err)
+			}
+			if hintFor(err) == "" {
+				t.Errorf("no hint for %v", err)
+			}
+		})
+	}
+}
+
+func TestTemplateRegexp(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		subject  string
+		matches  bool
+	}{
+		{"Bump version to {version_name}", "Bump version to 1.2.3", true},
+		{"Bump version to {version_name}", "Bump version to 1.2.3-rc.1+build.7", true},
+		{"Bump version to {version_name}", "Bump version to whatever", false},
+		{"Bump version to {version_name}", "Fix crash", false},
+		{"{version_name}: release bump", "1.2.3: release bump", true},
+		{"{version_name}: release bump", "Fix crash on start", false},
+		{"{version_name}: release bump", "Fix: release bump", false},
+		{"Release {version_name} ({version_code})", "Release 1.2 (457)", true},
+		{"Release {version_name} ({version_code})", "Release 1.2 (next)", false},
+		{"Build {date}", "Build 20240131", true},
+		{"Build {env:BITRISE_BUILD_NUMBER} of {version_name}", "Build 457 of 1.2.3", true},
+		{"chore(release): bump version to {version_name} [skip ci]", "chore(release): bump version to 1.2.3 [skip ci]", true},
+		{"chore(release): bump version to {version_name} [skip ci]", "chore(release): bump version to 1.2.3", false},
+	} {
+		if matches := templateRegexp(test.template).MatchString(test.subject); matches != test.matches {
+			t.Errorf("templateRegexp(%q) matches %q = %t, want %t", test.template, test.subject, matches, test.matches)
+		}
+	}
+}
+
+func TestBumpVersionNameEnvironmentSuffixes(t *testing.T) {
+	// each bump starts from the name the previous one wrote
+	name := "1.2.3"
+	for _, test := range []struct {
+		environment string
+		want        string
+	}{
+		{"staging", "1.2.4-staging"},
+		{"staging", "1.2.5-staging"},
+		{"production", "1.2.6"},
+		{"staging", "1.2.7-staging"},
+	} {
+		configs := testConfigs(t, map[string]string{"environment": test.environment})
+
+		bumped, err := bumpVersionName(configs, name)
+		if err != nil {
+			t.Fatalf("bumpVersionName(%s) in %s = %s", name, test.environment, err)
+		}
+		if bumped != test.want {
+			t.Errorf("bumpVersionName(%s) in %s = %s, want %s", name, test.environment, bumped, test.want)
+		}
+		name = bumped
+	}
+}
+
+func TestBumpVersionNameEnvironmentSuffixKeepsPreRelease(t *testing.T) {
+	configs := testConfigs(t, map[string]string{"environment": "staging", "bump_type": "prerelease-increment"})
+
+	bumped, err := bumpVersionName(configs, "1.2.3-rc.1.staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped != "1.2.3-rc.2.staging" {
+		t.Errorf("bumpVersionName() = %s, want 1.2.3-rc.2.staging", bumped)
+	}
+}
+
+func TestExportOnlyLeavesFilesUnchanged(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+	head := runGit(t, "rev-parse", "HEAD")
+
+	out, err := runStep(t, map[string]string{"mode": "export_only"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle changed to:\n%s", content)
+	}
+	if status := runGit(t, "status", "--porcelain"); status != "" {
+		t.Errorf("working tree changed:\n%s", status)
+	}
+	if sha := runGit(t, "rev-parse", "HEAD"); sha != head {
+		t.Errorf("HEAD moved from %s to %s", head, sha)
+	}
+
+	got := exports()
+	if got["BUMP_VERSION_NAME"] != "1.2.4" || got["BUMP_VERSION_CODE"] != "13" {
+		t.Errorf("exported %v, want BUMP_VERSION_NAME 1.2.4 and BUMP_VERSION_CODE 13", got)
+	}
+}
+
+func TestListMatchesExportsOnlyInExportingModes(t *testing.T) {
+	for _, test := range []struct {
+		mode    string
+		exports bool
+	}{
+		{"export_only", true},
+		{"plan", false},
+		{"inspect", false},
+		{"fail_if_bump_needed", false},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			newTestRepo(t)
+			exports := fakeEnvman(t)
+
+			out, _ := runStep(t, map[string]string{"mode": test.mode, "list_matches": "true", "read_ref": "HEAD"})
+			if !strings.Contains(out, "app/build.gradle") {
+				t.Errorf("matches not logged:\n%s", out)
+			}
+
+			matches, exported := exports()["BUMP_MATCHED_FILES"]
+			if exported != test.exports {
+				t.Errorf("BUMP_MATCHED_FILES exported = %t, want %t", exported, test.exports)
+			}
+			if exported && matches != "./app/build.gradle" {
+				t.Errorf("BUMP_MATCHED_FILES = %q, want ./app/build.gradle", matches)
+			}
+		})
+	}
+}
+
+func TestCommitMessageTemplate(t *testing.T) {
+	for _, test := range []struct {
+		commitType string
+		message    string
+		want       string
+	}{
+		{"", "Bump version to {version_name}", "Bump version to {version_name}"},
+		{"chore", "Bump version to {version_name}", "chore(release): bump version to {version_name}"},
+		{"build", "{version_name}: release", "build(release): {version_name}: release"},
+		{"chore", "Éditer la version {version_name}", "chore(release): éditer la version {version_name}"},
+		{"chore", "Ändere Version auf {version_name}", "chore(release): ändere Version auf {version_name}"},
+	} {
+		configs := testConfigs(t, map[string]string{"commit_type": test.commitType, "commit_message": test.message})
+		if template := configs.commitMessageTemplate(); template != test.want {
+			t.Errorf("commitMessageTemplate() of %q with type %q = %q, want %q", test.message, test.commitType, template, test.want)
+		}
+	}
+
+	configs := testConfigs(t, map[string]string{"commit_type": "chore"})
+	if message := renderTemplate(configs.commitMessageTemplate(), Versions{Name: "1.2.3", Code: 12}); message != "chore(release): bump version to 1.2.3" {
+		t.Errorf("commit message = %q, want the conventional commit", message)
+	}
+}
+
+func TestBumpVersionNameComponentOverrides(t *testing.T) {
+	for _, test := range []struct {
+		overrides map[string]string
+		want      string
+	}{
+		{map[string]string{"bump_type": "minor", "set_patch": "5"}, "1.3.5"},
+		{map[string]string{"bump_type": "minor", "set_patch": "0"}, "1.3.0"},
+		{map[string]string{"bump_type": "patch", "set_major": "2"}, "2.2.4"},
+		{map[string]string{"bump_type": "none", "set_major": "3", "set_minor": "0", "set_patch": "0"}, "3.0.0"},
+	} {
+		bumped, err := bumpVersionName(testConfigs(t, test.overrides), "1.2.3")
+		if err != nil {
+			t.Fatalf("bumpVersionName() with %v = %s", test.overrides, err)
+		}
+		if bumped != test.want {
+			t.Errorf("bumpVersionName() of 1.2.3 with %v = %s, want %s", test.overrides, bumped, test.want)
+		}
+	}
+}
+
+func TestComponentOverridesValidation(t *testing.T) {
+	for _, value := range []string{"-1", "x", "1.5"} {
+		if _, err := testConfigs(t, map[string]string{"set_patch": value}).componentOverrides(); err == nil {
+			t.Errorf("componentOverrides() accepted set_patch %q", value)
+		}
+	}
+}
+
+func TestVersionFromTag(t *testing.T) {
+	local := map[string]string{"version_from_tag": "true", "do_push_branch": "false", "do_merge": "false", "do_tag": "false"}
+	for _, test := range []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"no tag falls back to the file", nil, "1.2.4"},
+		{"latest tag", []string{"1.0.0", "2.0.0", "1.9.9"}, "2.0.1"},
+		{"non semver tags ignored", []string{"2.0.0", "release-candidate"}, "2.0.1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newTestRepo(t)
+			fakeEnvman(t)
+			for _, tag := range test.tags {
+				runGit(t, "tag", tag)
+			}
+
+			out, err := runStep(t, local)
+			if err != nil {
+				t.Fatalf("step failed: %s\n%s", err, out)
+			}
+
+			if content := readFixture(t, "app/build.gradle"); !strings.Contains(content, `versionName "`+test.want+`"`) {
+				t.Errorf("app/build.gradle =\n%s\nwant versionName %s", content, test.want)
+			}
+		})
+	}
+}
+
+func TestBumpVersionsMinVersionCode(t *testing.T) {
+	for _, test := range []struct {
+		floor string
+		want  int
+	}{
+		{"", 13},
+		{"100", 100},
+		{"13", 13},
+		{"5", 13},
+	} {
+		bumped, err := bumpVersions(testConfigs(t, map[string]string{"min_version_code": test.floor}), Versions{Name: "1.2.3", Code: 12})
+		if err != nil {
+			t.Fatalf("bumpVersions() with min_version_code %q = %s", test.floor, err)
+		}
+		if bumped.Code != test.want {
+			t.Errorf("bumpVersions() of 12 with min_version_code %q = %d, want %d", test.floor, bumped.Code, test.want)
+		}
+	}
+}
+
+func TestMinVersionCodeValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	for _, floor := range []string{"0", "-1", "x", "2147483648"} {
+		if _, err := testConfigs(t, map[string]string{"min_version_code": floor}).validate(); err == nil {
+			t.Errorf("validate() accepted min_version_code %q", floor)
+		}
+	}
+	if _, err := testConfigs(t, map[string]string{"min_version_code": "2147483647"}).validate(); err != nil {
+		t.Errorf("validate() with the largest min_version_code = %s", err)
+	}
+}
+
+func TestReleaseTags(t *testing.T) {
+	versions := Versions{Name: "1.2.4", Code: 457}
+	for _, test := range []struct {
+		overrides map[string]string
+		versions  Versions
+		want      []string
+	}{
+		{nil, versions, []string{"1.2.4"}},
+		{map[string]string{"code_tag_template": "build-{version_code}"}, versions, []string{"1.2.4", "build-457"}},
+		{map[string]string{"code_tag_template": "build-{version_code}", "tag_scope": "app-v"}, versions, []string{"app-v1.2.4", "build-457"}},
+		{map[string]string{"code_tag_template": "build-{version_code}"}, Versions{Code: 457}, []string{"build-457"}},
+	} {
+		if tags := releaseTags(testConfigs(t, test.overrides), test.versions); !equalStrings(tags, test.want) {
+			t.Errorf("releaseTags() with %v = %v, want %v", test.overrides, tags, test.want)
+		}
+	}
+}
+
+func TestCodeTagTemplateValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	for _, test := range []struct {
+		template string
+		valid    bool
+	}{
+		{"build-{version_code}", true},
+		{"codes/{version_code}", true},
+		{"build", false},
+		{"build {version_code}", false},
+		{"build~{version_code}", false},
+		{"{version_code}.lock", false},
+	} {
+		_, err := testConfigs(t, map[string]string{"code_tag_template": test.template}).validate()
+		if (err == nil) != test.valid {
+			t.Errorf("validate() of code_tag_template %q = %v, want valid %t", test.template, err, test.valid)
+		}
+	}
+}
+
+func TestStripBuildMetadataOnWrite(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{
+		"build_metadata_env":            "CI_BUILD",
+		"CI_BUILD":                      "457",
+		"strip_build_metadata_on_write": "true",
+		"do_push_branch":                "false",
+		"do_merge":                      "false",
+		"push_tags":                     "false",
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if content := readFixture(t, "app/build.gradle"); !strings.Contains(content, `versionName "1.2.4"`) {
+		t.Errorf("app/build.gradle =\n%s\nwant the stripped versionName 1.2.4", content)
+	}
+	if tag := runGit(t, "tag", "--list"); tag != "1.2.4+build.457" {
+		t.Errorf("tags = %q, want 1.2.4+build.457", tag)
+	}
+	if name := exports()["BUMP_VERSION_NAME"]; name != "1.2.4+build.457" {
+		t.Errorf("BUMP_VERSION_NAME = %q, want 1.2.4+build.457", name)
+	}
+}
+
+func TestFailIfBumpNeeded(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		code   string
+		vname  string
+		needed bool
+	}{
+		{"not bumped", "12", "1.2.3", true},
+		{"bumped", "13", "1.2.4", false},
+		{"bumped further", "20", "2.0.0", false},
+		{"only versionName bumped", "12", "1.2.4", true},
+		{"only versionCode bumped", "13", "1.2.3", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newTestRepo(t)
+			writeFixture(t, "app/build.gradle", strings.NewReplacer("versionCode 12", "versionCode "+test.code, `"1.2.3"`, `"`+test.vname+`"`).Replace(buildGradleFixture))
+			runGit(t, "commit", "-q", "--allow-empty", "-am", "Feature")
+
+			out, err := runStep(t, map[string]string{"mode": "fail_if_bump_needed", "read_ref": "master"})
+			if test.needed {
+				if err == nil || !strings.Contains(out, "A bump is needed for: ") {
+					t.Errorf("step error = %v, want a bump needed:\n%s", err, out)
+				}
+			} else if err != nil {
+				t.Errorf("step failed: %s\n%s", err, out)
+			}
+			if content := readFixture(t, "app/build.gradle"); !strings.Contains(content, "versionCode "+test.code+"\n") {
+				t.Errorf("app/build.gradle changed to:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestFailIfBumpNeededNeedsReference(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	if _, err := testConfigs(t, map[string]string{"mode": "fail_if_bump_needed"}).validate(); err == nil {
+		t.Error("validate() accepted fail_if_bump_needed without a reference")
+	}
+	for _, reference := range []map[string]string{
+		{"read_ref": "origin/main"},
+		{"remote_version_url": "https://example.com/version"},
+		{"skip_if_at_target": "true", "set_major": "1", "set_minor": "2", "set_patch": "3"},
+	} {
+		reference["mode"] = "fail_if_bump_needed"
+		if _, err := testConfigs(t, reference).validate(); err != nil {
+			t.Errorf("validate() with %v = %s", reference, err)
+		}
+	}
+}
+
+func TestFindBuildGradleFilesSkipsBuildOutputDecoys(t *testing.T) {
+	for _, test := range []struct {
+		overrides map[string]string
+		want      string
+		err       error
+	}{
+		{nil, "", ErrMultipleFiles},
+		{map[string]string{"exclude_path_pattern": `(^|/)build/`}, "./app/build.gradle", nil},
+		{map[string]string{"include_path_pattern": `^app/build\.gradle$`}, "./app/build.gradle", nil},
+		{map[string]string{"include_path_pattern": `^wear/`}, "", ErrFileNotFound},
+	} {
+		t.Chdir(t.TempDir())
+		writeFixture(t, "app/build.gradle", buildGradleFixture)
+		writeFixture(t, "app/build/intermediates/merged/build.gradle", buildGradleFixture)
+		writeFixture(t, "build/tmp/build.gradle", buildGradleFixture)
+
+		files, err := findBuildGradleFiles(testConfigs(t, test.overrides))
+		if !errors.Is(err, test.err) {
+			t.Errorf("findBuildGradleFiles() with %v error = %v, want %v", test.overrides, err, test.err)
+			continue
+		}
+		if test.err == nil && !equalStrings(files, []string{test.want}) {
+			t.Errorf("findBuildGradleFiles() with %v = %v, want %s", test.overrides, files, test.want)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	t.Setenv("CI_BUILD", "457")
+	versions := Versions{Name: "1.2.4", Code: 13}
+	date := time.Now().UTC().Format("20060102")
+
+	for _, test := range []struct {
+		template string
+		want     string
+	}{
+		{"release/{version_name}", "release/1.2.4"},
+		{"release/{version_name}-{version_code}", "release/1.2.4-13"},
+		{"release/{version_name}-{env:CI_BUILD}", "release/1.2.4-457"},
+		{"nightly/{date}", "nightly/" + date},
+		{"release/{env:UNSET_BUMP_TEST_VARIABLE}x", "release/x"},
+		{"release/{env:not valid}", "release/{env:not valid}"},
+	} {
+		if rendered := renderTemplate(test.template, versions); rendered != test.want {
+			t.Errorf("renderTemplate(%q) = %q, want %q", test.template, rendered, test.want)
+		}
+	}
+}
+
+func TestMergeBranchTemplate(t *testing.T) {
+	newTestRepo(t)
+	runGit(t, "branch", "release/1.2.4-457")
+	versions := Versions{Name: "1.2.4", Code: 13}
+
+	for _, test := range []struct {
+		branchEnv string
+		want      string
+		err       string
+	}{
+		{"457", "release/1.2.4-457", ""},
+		{"458", "", "does not exist"},
+		{"a..b", "", "not a valid git branch name"},
+		{"with space", "", "not a valid git branch name"},
+	} {
+		t.Setenv("CI_BUILD", test.branchEnv)
+
+		branch, err := mergeBranch(testConfigs(t, map[string]string{"merge_branch": "release/{version_name}-{env:CI_BUILD}"}), versions)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("mergeBranch() with CI_BUILD %q error = %v, want %q", test.branchEnv, err, test.err)
+			}
+			continue
+		}
+		if err != nil || branch != test.want {
+			t.Errorf("mergeBranch() with CI_BUILD %q = %s, %v, want %s", test.branchEnv, branch, err, test.want)
+		}
+	}
+}
+
+func TestIsBranchAllowed(t *testing.T) {
+	for _, test := range []struct {
+		allowed string
+		branch  string
+		want    bool
+	}{
+		{"", "feature/login", true},
+		{"master, develop", "develop", true},
+		{"master, develop", "feature/login", false},
+		{"release/*", "release/1.2", true},
+		{"release/*", "release/1.2/hotfix", false},
+		{"release/*", "release", false},
+		{"master,hotfix-?", "hotfix-1", true},
+		{"master,hotfix-?", "hotfix-12", false},
+	} {
+		if allowed := testConfigs(t, map[string]string{"allowed_branches": test.allowed}).isBranchAllowed(test.branch); allowed != test.want {
+			t.Errorf("isBranchAllowed(%s) with allowed_branches %q = %t, want %t", test.branch, test.allowed, allowed, test.want)
+		}
+	}
+}
+
+func TestAllowedBranchesRefusesOtherBranches(t *testing.T) {
+	newTestRepo(t)
+	fakeEnvman(t)
+	local := map[string]string{"allowed_branches": "master,release/*", "do_push_branch": "false", "do_merge": "false", "do_tag": "false"}
+
+	out, err := runStep(t, local)
+	if err == nil || !strings.Contains(out, "Branch develop is not in allowed_branches") {
+		t.Fatalf("step on develop error = %v, want it refused:\n%s", err, out)
+	}
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle changed on a refused branch:\n%s", content)
+	}
+
+	runGit(t, "checkout", "-q", "-b", "release/1.2")
+	if out, err := runStep(t, local); err != nil {
+		t.Fatalf("step on release/1.2 failed: %s\n%s", err, out)
+	}
+	if subject := runGit(t, "log", "-1", "--format=%s"); subject != "Bump version to 1.2.4" {
+		t.Errorf("last commit on release/1.2 = %q, want the bump", subject)
+	}
+}
+
+func TestAllowedBranchesValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	if _, err := testConfigs(t, map[string]string{"allowed_branches": "release/[1-"}).validate(); err == nil {
+		t.Error("validate() accepted the malformed glob release/[1-")
+	}
+}
+
+func TestMissingFileBehavior(t *testing.T) {
+	for _, test := range []struct {
+		behavior string
+		fails    bool
+	}{
+		{"skip", false},
+		{"fail", true},
+	} {
+		t.Run(test.behavior, func(t *testing.T) {
+			// a repository of another project sharing the workflow, without a gradle file
+			newTestRepo(t)
+			runGit(t, "rm", "-q", "app/build.gradle")
+			runGit(t, "commit", "-q", "-m", "Remove the app")
+			addTestRemote(t, "origin")
+			exports := fakeEnvman(t)
+
+			out, err := runStep(t, map[string]string{"missing_file_behavior": test.behavior})
+			if (err != nil) != test.fails {
+				t.Fatalf("step error = %v, want failed %t:\n%s", err, test.fails, out)
+			}
+			if !test.fails && !strings.Contains(out, "No `build.gradle` file found, skipping the bump") {
+				t.Errorf("skip not logged:\n%s", out)
+			}
+			if test.fails && !strings.Contains(out, "Failed to find `build.gradle` file") {
+				t.Errorf("missing file not reported:\n%s", out)
+			}
+			if got := exports(); len(got) > 0 {
+				t.Errorf("exported %v without a file", got)
+			}
+		})
+	}
+}
+
+func TestSharedVersionFiles(t *testing.T) {
+	bumped := strings.NewReplacer("versionCode 12", "versionCode 13", `"1.2.3"`, `"1.2.4"`).Replace(buildGradleFixture)
+	for _, test := range []struct {
+		name        string
+		shared      string
+		forceResync string
+		fails       bool
+	}{
+		{"aligned", buildGradleFixture, "false", false},
+		{"diverged", strings.Replace(buildGradleFixture, "versionCode 12", "versionCode 11", 1), "false", true},
+		{"force resync", strings.Replace(buildGradleFixture, `"1.2.3"`, `"1.1.0"`, 1), "true", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newTestRepo(t)
+			writeFixture(t, "wear/build.gradle", test.shared)
+			runGit(t, "add", "-A")
+			runGit(t, "commit", "-q", "-m", "Add wear")
+			fakeEnvman(t)
+
+			out, err := runStep(t, map[string]string{
+				"gradle_file_path":     "app/build.gradle",
+				"shared_version_files": "wear/build.gradle",
+				"force_resync":         test.forceResync,
+				"do_push_branch":       "false",
+				"do_merge":             "false",
+				"do_tag":               "false",
+			})
+			if (err != nil) != test.fails {
+				t.Fatalf("step error = %v, want failed %t:\n%s", err, test.fails, out)
+			}
+
+			if test.fails {
+				if !strings.Contains(out, "wear/build.gradle: versionCode 11, app/build.gradle has 12") {
+					t.Errorf("divergence not listed:\n%s", out)
+				}
+				if status := runGit(t, "status", "--porcelain"); status != "" {
+					t.Errorf("diverged files changed:\n%s", status)
+				}
+				return
+			}
+			for _, file := range []string{"app/build.gradle", "wear/build.gradle"} {
+				if content := readFixture(t, file); content != bumped {
+					t.Errorf("%s =\n%s\nwant\n%s", file, content, bumped)
+				}
+			}
+			if files := runGit(t, "show", "--name-only", "--format=", "HEAD"); files != "app/build.gradle\nwear/build.gradle" {
+				t.Errorf("bump commit changed %q, want both files", files)
+			}
+		})
+	}
+}
+
+func TestVersionConsumerCommitsOnlyWhatItChanged(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "notes.txt", "Release notes\n")
+	writeFixture(t, "VERSION", "1.2.3\n")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Add notes")
+	fakeEnvman(t)
+	// the notes were edited before the step, the version file the command rewrites too
+	writeFixture(t, "notes.txt", "Release notes\n- draft\n")
+	writeFixture(t, "VERSION", "draft\n")
+
+	out, err := runStep(t, map[string]string{
+		"version_consumer_command": `echo "$BUMP_VERSION_NAME" > VERSION`,
+		"do_push_branch":           "false",
+		"do_merge":                 "false",
+		"do_tag":                   "false",
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if files := runGit(t, "show", "--name-only", "--format=", "HEAD"); files != "VERSION" {
+		t.Errorf("bump commit changed %q, want only the VERSION the command wrote", files)
+	}
+	if status := runGit(t, "status", "--porcelain"); status != "M notes.txt" {
+		t.Errorf("status = %q, want notes.txt left uncommitted", status)
+	}
+}
+
+func TestVersionConsumerRejectsFilesTheStepWrites(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	writeFixture(t, "wear/build.gradle", buildGradleFixture)
+	writeFixture(t, "pubspec.yaml", "version: 1.2.3+12\n")
+
+	for _, overrides := range []map[string]string{
+		{"write_version_file": "VERSION"},
+		{"shared_version_files": "wear/build.gradle"},
+		{"version_source": "pubspec", "pubspec_sync_gradle_file": "app/build.gradle"},
+	} {
+		overrides["version_consumer_command"] = "true"
+		if _, err := testConfigs(t, overrides).validate(); err == nil || !strings.HasPrefix(err.Error(), "Version consumer command with ") {
+			t.Errorf("validate() with %v error = %v, want the combination rejected", overrides, err)
+		}
+	}
+}
+
+func TestNameFromBuild(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	t.Setenv("BITRISE_BUILD_NUMBER", "457")
+	configs := testConfigs(t, map[string]string{"name_from_build": "true", "name_base": "1.2"})
+
+	if _, err := configs.validate(); err != nil {
+		t.Fatalf("validate() = %s", err)
+	}
+	for _, name := range []string{"1.2.3", "0.9.0"} {
+		if bumped, err := bumpVersionName(configs, name); err != nil || bumped != "1.2.457" {
+			t.Errorf("bumpVersionName(%s) = %s, %v, want 1.2.457", name, bumped, err)
+		}
+	}
+
+	for _, build := range []string{"", "x", "-1", "4.5"} {
+		t.Setenv("BITRISE_BUILD_NUMBER", build)
+		if _, err := configs.validate(); err == nil {
+			t.Errorf("validate() accepted build number %q", build)
+		}
+	}
+	t.Setenv("BITRISE_BUILD_NUMBER", "457")
+	for _, base := range []string{"1", "1.2.3", "v1.2", ""} {
+		if _, err := testConfigs(t, map[string]string{"name_from_build": "true", "name_base": base}).validate(); err == nil {
+			t.Errorf("validate() accepted name_base %q", base)
+		}
+	}
+}
+
+func TestIsAtExplicitVersion(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	explicit := map[string]string{"skip_if_at_target": "true", "set_major": "1", "set_minor": "2", "set_patch": "3"}
+
+	for _, test := range []struct {
+		name        string
+		environment string
+		want        bool
+	}{
+		{"1.2.3", "", true},
+		{"1.2.3-staging", "production", true},
+		{"1.2.3", "staging", true},
+		{"1.2.3-rc.1", "", false},
+		{"1.2.3+build.7", "", false},
+		{"1.2.2", "", false},
+		{"1.3.0", "", false},
+	} {
+		overrides := map[string]string{"environment": test.environment}
+		for key, value := range explicit {
+			overrides[key] = value
+		}
+
+		atTarget, err := testConfigs(t, overrides).isAtExplicitVersion(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if atTarget != test.want {
+			t.Errorf("isAtExplicitVersion(%s) in %q = %t, want %t", test.name, test.environment, atTarget, test.want)
+		}
+	}
+}
+
+func TestSkipIfAtTarget(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	fakeEnvman(t)
+	head := runGit(t, "rev-parse", "HEAD")
+
+	out, err := runStep(t, map[string]string{"skip_if_at_target": "true", "set_major": "1", "set_minor": "2", "set_patch": "3"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if !strings.Contains(out, "versionName 1.2.3 already is the set version, skipping") {
+		t.Errorf("skip not logged:\n%s", out)
+	}
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle changed to:\n%s", content)
+	}
+	if sha := runGit(t, "rev-parse", "HEAD"); sha != head {
+		t.Errorf("HEAD moved from %s to %s", head, sha)
+	}
+}
+
+func TestRequireCoupledBumpValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	for _, test := range []struct {
+		overrides map[string]string
+		coupled   bool
+	}{
+		{map[string]string{"bump_type": "patch"}, true},
+		{map[string]string{"bump_type": "minor", "code_increment": "10"}, true},
+		{map[string]string{"bump_type": "none", "set_minor": "3", "code_increment": "1"}, true},
+		{map[string]string{"bump_type": "patch", "code_increment": "0"}, false},
+		{map[string]string{"bump_type": "none"}, false},
+	} {
+		test.overrides["require_coupled_bump"] = "true"
+		_, err := testConfigs(t, test.overrides).validate()
+		if test.coupled && err != nil {
+			t.Errorf("validate() with %v = %s", test.overrides, err)
+		}
+		if !test.coupled && (err == nil || err.Error() != "Decoupled bump!") {
+			t.Errorf("validate() with %v error = %v, want the decoupled bump", test.overrides, err)
+		}
+	}
+}
+
+func TestCheckCoupledBump(t *testing.T) {
+	old := Versions{Name: "1.2.3", Code: 12}
+	for _, test := range []struct {
+		bumped Versions
+		err    string
+	}{
+		{Versions{Name: "1.2.4", Code: 13}, ""},
+		{old, ""},
+		{Versions{Name: "1.2.3", Code: 13}, "versionCode changes from 12 to 13, but versionName stays 1.2.3"},
+		{Versions{Name: "1.2.4", Code: 12}, "versionName changes from 1.2.3 to 1.2.4, but versionCode stays 12"},
+	} {
+		err := checkCoupledBump(old, test.bumped)
+		if test.err == "" && err != nil {
+			t.Errorf("checkCoupledBump(%+v) = %s", test.bumped, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("checkCoupledBump(%+v) error = %v, want %q", test.bumped, err, test.err)
+		}
+	}
+}
+
+func TestMinVersionCodeFileOrFloorWins(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		code      int
+		floor     string
+		increment string
+		want      int
+	}{
+		{"file bumped manually above the counter wins", 500, "120", "1", 501},
+		{"file wins with a larger increment", 500, "502", "5", 505},
+		{"floor wins", 500, "900", "1", 900},
+		{"floor wins over the increment", 500, "504", "3", 504},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configs := testConfigs(t, map[string]string{"bump_type": "none", "min_version_code": test.floor, "code_increment": test.increment})
+
+			bumped, err := bumpVersions(configs, Versions{Name: "1.2.3", Code: test.code})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bumped.Code != test.want || bumped.Name != "1.2.3" {
+				t.Errorf("bumpVersions() of %d with min_version_code %s = %s (%d), want 1.2.3 (%d)", test.code, test.floor, bumped.Name, bumped.Code, test.want)
+			}
+		})
+	}
+
+	if _, err := bumpVersions(testConfigs(t, map[string]string{"bump_type": "none", "min_version_code": "100"}), Versions{Name: "1.2.3", Code: 2147483647}); err == nil || !strings.Contains(err.Error(), "overflows int32") {
+		t.Errorf("bumpVersions() past the int32 bound error = %v, want the overflow", err)
+	}
+}
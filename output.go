@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+// outputKeys are the outputs of the step declared in step.yml, a module exports them qualified with it too.
+var outputKeys = []string{"BUMP_VERSION_NAME", "BUMP_VERSION_CODE", "BUMP_EXTRA_CODE", "BUMP_COMMIT_SHA", "BUMP_TAG_NAME", "BUMP_CODE_TAG_NAME", "BUMP_MATCHED_FILES"}
+
+// versionEmitter publishes the new versions in one format, every enabled emitter runs on each bump.
+type versionEmitter struct {
+	Name string
+	Emit func(versions Versions) error
+}
+
+// versionEmitters returns the emitters enabled by the configs, old is used to skip an unchanged versionName.
+// The file emitters record the versions in emitted by module, as each file lists every module bumped in the run.
+func versionEmitters(configs ConfigsModel, module string, old Versions, emitted map[string]Versions) []versionEmitter {
+	emitters := []versionEmitter{}
+
+	if len(outputSinks(configs)) > 0 {
+		emitters = append(emitters, versionEmitter{
+			Name: "outputs",
+			Emit: func(versions Versions) error {
+				exportModuleOutput(configs, module, "BUMP_VERSION_CODE", strconv.Itoa(versions.Code))
+				if configs.ExtraCodeField != "" {
+					exportModuleOutput(configs, module, "BUMP_EXTRA_CODE", strconv.Itoa(versions.ExtraCode))
+				}
+				if versions.Name == "" {
+					log.Detail("No versionName, BUMP_VERSION_NAME not exported")
+				} else if versions.Name != old.Name || configs.ExportUnchangedName == "true" {
+					exportModuleOutput(configs, module, "BUMP_VERSION_NAME", versions.Name)
+				} else {
+					log.Detail("versionName unchanged, BUMP_VERSION_NAME not exported")
+				}
+				return nil
+			},
+		})
+	}
+
+	if configs.VersionOutputFile != "" {
+		emitters = append(emitters, versionEmitter{
+			Name: configs.VersionOutputFile,
+			Emit: func(versions Versions) error {
+				emitted[module] = versions
+				return writeVersionOutputFile(configs, configs.VersionOutputFile, emitted)
+			},
+		})
+	}
+
+	if configs.VersionJSONFile != "" {
+		emitters = append(emitters, versionEmitter{
+			Name: configs.VersionJSONFile,
+			Emit: func(versions Versions) error {
+				emitted[module] = versions
+				return writeVersionJSONFile(configs.VersionJSONFile, emitted)
+			},
+		})
+	}
+
+	return emitters
+}
+
+// emitVersions runs every emitter and fails the step on the first error.
+func emitVersions(emitters []versionEmitter, versions Versions) {
+	for _, emitter := range emitters {
+		if err := emitter.Emit(versions); err != nil {
+			log.Fail("Failed to write versions to %s: %s", emitter.Name, err)
+		}
+	}
+}
+
+// writeVersionOutputFile writes the versions as KEY=value lines for consumers without envman,
+// the keys of a module are qualified with it, e.g. BUMP_VERSION_NAME_WEAR.
+func writeVersionOutputFile(configs ConfigsModel, file string, modules map[string]Versions) error {
+	content := ""
+	for _, module := range versionModules(modules) {
+		versions := modules[module]
+		content += fmt.Sprintf("%s=%s\n", qualifiedOutputKey("BUMP_VERSION_NAME", module), versions.Name)
+		content += fmt.Sprintf("%s=%d\n", qualifiedOutputKey("BUMP_VERSION_CODE", module), versions.Code)
+		if configs.ExtraCodeField != "" {
+			content += fmt.Sprintf("%s=%d\n", qualifiedOutputKey("BUMP_EXTRA_CODE", module), versions.ExtraCode)
+		}
+	}
+
+	return ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// writeVersionJSONFile writes the versions as a JSON object, e.g. for dashboards,
+// or module bumps as an object of them keyed by the module.
+func writeVersionJSONFile(file string, modules map[string]Versions) error {
+	if versions, ok := modules[""]; ok && len(modules) == 1 {
+		return writeJSONFile(file, versions)
+	}
+
+	return writeJSONFile(file, modules)
+}
+
+func writeJSONFile(file string, value interface{}) error {
+	bytes, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append(bytes, '\n'), 0644)
+}
+
+func versionModules(modules map[string]Versions) []string {
+	names := []string{}
+	for module := range modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// qualifiedOutputKey qualifies the output with the module, a run without modules uses the key as is.
+func qualifiedOutputKey(key, module string) string {
+	if module == "" {
+		return key
+	}
+
+	return moduleOutputKey(key, module)
+}
+
+const envmanAttempts = 3
+
+var envmanRetryDelay = time.Second
+
+// exportEnvironmentWithEnvman retries, envman add occasionally fails transiently.
+func exportEnvironmentWithEnvman(key, value string) error {
+	var err error
+	for attempt := 1; attempt <= envmanAttempts; attempt++ {
+		cmd := command.New("envman", "add", "--key", key)
+		cmd.SetStdin(strings.NewReader(value))
+		if err = cmd.Run(); err == nil {
+			return nil
+		}
+		if attempt < envmanAttempts {
+			log.Warn("Failed to export %s (attempt %d/%d): %s", key, attempt, envmanAttempts, err)
+			time.Sleep(envmanRetryDelay)
+		}
+	}
+
+	return err
+}
+
+// exportModuleOutput also exports the output qualified with the module, if any.
+func exportModuleOutput(configs ConfigsModel, module, key, value string) {
+	exportOutput(configs, key, value)
+	if module != "" {
+		exportOutput(configs, moduleOutputKey(key, module), value)
+	}
+}
+
+// outputSink receives every exported output, e.g. envman or the GitHub Actions output file,
+// each is enabled on its own.
+type outputSink struct {
+	Name   string
+	Export func(key, value string) error
+	// Warn only warns when the export fails instead of failing the step
+	Warn bool
+}
+
+// outputSinks returns the sinks enabled by the configs, every output goes to each of them.
+func outputSinks(configs ConfigsModel) []outputSink {
+	sinks := []outputSink{}
+
+	if file := configs.gitHubOutputFile(); file != "" {
+		sinks = append(sinks, outputSink{
+			Name: file,
+			Export: func(key, value string) error {
+				return appendGitHubOutput(file, key, value)
+			},
+		})
+	}
+
+	if configs.ExportEnvman == "true" {
+		sinks = append(sinks, outputSink{
+			Name:   "envman",
+			Export: exportEnvironmentWithEnvman,
+			Warn:   configs.EnvmanFailure == "warn",
+		})
+	}
+
+	return sinks
+}
+
+// exportOutput fails the step or only warns when the export keeps failing, the bumped file stays the source of truth.
+func exportOutput(configs ConfigsModel, key, value string) {
+	for _, sink := range outputSinks(configs) {
+		if err := sink.Export(key, value); err != nil {
+			if sink.Warn {
+				log.Warn("Failed to export enviroment (%s) with %s, continuing: %s", key, sink.Name, err)
+				continue
+			}
+			log.Fail("Failed to export enviroment (%s) with %s: %s", key, sink.Name, err)
+		}
+	}
+}
+
+// gitHubOutputFile returns the file GitHub Actions reads step outputs from, if github_output enables it.
+func (configs ConfigsModel) gitHubOutputFile() string {
+	if configs.GitHubOutput == "false" {
+		return ""
+	}
+
+	return os.Getenv("GITHUB_OUTPUT")
+}
+
+// appendGitHubOutput appends a `name=value` line, or a `name<<DELIMITER` block for a value spanning
+// several lines, e.g. BUMP_MATCHED_FILES. A later output of the same name wins.
+func appendGitHubOutput(file, key, value string) error {
+	line := fmt.Sprintf("%s=%s\n", key, value)
+	if strings.Contains(value, "\n") {
+		// the delimiter must not be a line of the value
+		delimiter := "BUMP_EOF"
+		for strings.Contains(value, delimiter) {
+			delimiter += "_"
+		}
+		line = fmt.Sprintf("%s<<%s\n%s\n%s\n", key, delimiter, value, delimiter)
+	}
+
+	output, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := output.WriteString(line); err != nil {
+		output.Close()
+		return err
+	}
+
+	return output.Close()
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeEnvman puts an envman on the PATH that stores every exported value in a file named by its key,
+// and returns a function reading the exports. A later export of a key replaces the earlier one, like in envman.
+func fakeEnvman(t *testing.T) func() map[string]string {
+	t.Helper()
+
+	bin := t.TempDir()
+	exports := t.TempDir()
+	writeFixture(t, filepath.Join(bin, "envman"), "#!/bin/sh\n# envman add --key KEY, the value is read from stdin\ncat > \"$FAKE_ENVMAN_DIR/$3\"\n")
+	if err := os.Chmod(filepath.Join(bin, "envman"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_ENVMAN_DIR", exports)
+
+	return func() map[string]string {
+		t.Helper()
+
+		files, err := ioutil.ReadDir(exports)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]string{}
+		for _, file := range files {
+			values[file.Name()] = readFixture(t, filepath.Join(exports, file.Name()))
+		}
+		return values
+	}
+}
+
+func TestExportCommitSHAAndTagName(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+	configs := testConfigs(t, nil)
+
+	sha, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sha) != 40 {
+		t.Fatalf("captured commit SHA %q is not a trimmed 40 character SHA", sha)
+	}
+
+	exportModuleOutput(configs, "", "BUMP_COMMIT_SHA", sha)
+	exportModuleOutput(configs, ":feature:wear", "BUMP_TAG_NAME", "1.2.4")
+
+	want := map[string]string{
+		"BUMP_COMMIT_SHA":            sha,
+		"BUMP_TAG_NAME":              "1.2.4",
+		"BUMP_TAG_NAME_FEATURE_WEAR": "1.2.4",
+	}
+	got := exports()
+	if len(got) != len(want) {
+		t.Errorf("exported %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+// flakyEnvman puts an envman on the PATH that fails its first `failures` calls,
+// and returns a function counting the calls.
+func flakyEnvman(t *testing.T, failures int) func() int {
+	t.Helper()
+
+	bin := t.TempDir()
+	calls := filepath.Join(t.TempDir(), "calls")
+	writeFixture(t, filepath.Join(bin, "envman"), fmt.Sprintf("#!/bin/sh\necho call >> %q\n[ $(wc -l < %q) -gt %d ]\n", calls, calls, failures))
+	if err := os.Chmod(filepath.Join(bin, "envman"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	delay := envmanRetryDelay
+	envmanRetryDelay = 0
+	t.Cleanup(func() { envmanRetryDelay = delay })
+
+	return func() int {
+		t.Helper()
+
+		if _, err := os.Stat(calls); os.IsNotExist(err) {
+			return 0
+		}
+		return strings.Count(readFixture(t, calls), "call\n")
+	}
+}
+
+func TestExportEnvironmentWithEnvmanRetries(t *testing.T) {
+	for _, test := range []struct {
+		failures int
+		calls    int
+		fails    bool
+	}{
+		{0, 1, false},
+		{2, 3, false},
+		{3, 3, true},
+	} {
+		calls := flakyEnvman(t, test.failures)
+
+		err := exportEnvironmentWithEnvman("BUMP_VERSION_NAME", "1.2.4")
+		if (err != nil) != test.fails {
+			t.Errorf("exportEnvironmentWithEnvman() with %d failures = %v, want failing %t", test.failures, err, test.fails)
+		}
+		if got := calls(); got != test.calls {
+			t.Errorf("envman called %d times with %d failures, want %d", got, test.failures, test.calls)
+		}
+	}
+}
+
+func TestExportOutputEnvmanFailureWarn(t *testing.T) {
+	calls := flakyEnvman(t, envmanAttempts)
+
+	// with warn a failing export returns instead of failing the step
+	exportOutput(testConfigs(t, map[string]string{"envman_failure": "warn"}), "BUMP_VERSION_NAME", "1.2.4")
+
+	if got := calls(); got != envmanAttempts {
+		t.Errorf("envman called %d times, want %d", got, envmanAttempts)
+	}
+}
+
+func TestExportOutputEnvmanFailureFail(t *testing.T) {
+	newTestRepo(t)
+	flakyEnvman(t, 100)
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "envman_failure": "fail"})
+	if err == nil {
+		t.Fatalf("step succeeded with a failing envman:\n%s", out)
+	}
+	if !strings.Contains(out, "Failed to export enviroment (BUMP_VERSION_CODE) with envman") {
+		t.Errorf("failure not reported:\n%s", out)
+	}
+}
+
+func TestWriteVersionOutputFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "version.env")
+	versions := Versions{Name: "1.2.4", Code: 13, ExtraCode: 7}
+
+	if err := writeVersionOutputFile(testConfigs(t, nil), file, map[string]Versions{"": versions}); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFixture(t, file); content != "BUMP_VERSION_NAME=1.2.4\nBUMP_VERSION_CODE=13\n" {
+		t.Errorf("version output file = %q", content)
+	}
+
+	if err := writeVersionOutputFile(testConfigs(t, map[string]string{"extra_code_field": "wearVersionCode"}), file, map[string]Versions{"": versions}); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFixture(t, file); content != "BUMP_VERSION_NAME=1.2.4\nBUMP_VERSION_CODE=13\nBUMP_EXTRA_CODE=7\n" {
+		t.Errorf("version output file with the extra code = %q", content)
+	}
+}
+
+func TestVersionOutputFileWithoutEnvman(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+	file := filepath.Join(t.TempDir(), "version.env")
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "version_output_file": file, "export_envman": "false"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if content := readFixture(t, file); content != "BUMP_VERSION_NAME=1.2.4\nBUMP_VERSION_CODE=13\n" {
+		t.Errorf("version output file = %q", content)
+	}
+	if got := exports(); len(got) != 0 {
+		t.Errorf("exported %v with export_envman false", got)
+	}
+}
+
+func TestModuleBumpsKeepEveryModuleInTheFiles(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "wear/build.gradle", strings.Replace(buildGradleFixture, "versionCode 12", "versionCode 40", 1))
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "Add wear")
+	fakeEnvman(t)
+	dir := t.TempDir()
+
+	out, err := runStep(t, map[string]string{
+		"mode":                "export_only",
+		"module_bump_types":   "app=minor,wear=patch",
+		"version_output_file": filepath.Join(dir, "version.env"),
+		"version_json_file":   filepath.Join(dir, "version.json"),
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	wantOutput := "BUMP_VERSION_NAME_APP=1.3.0\nBUMP_VERSION_CODE_APP=13\nBUMP_VERSION_NAME_WEAR=1.2.4\nBUMP_VERSION_CODE_WEAR=41\n"
+	if content := readFixture(t, filepath.Join(dir, "version.env")); content != wantOutput {
+		t.Errorf("version output file =\n%s\nwant\n%s", content, wantOutput)
+	}
+
+	var versions map[string]Versions
+	if err := json.Unmarshal([]byte(readFixture(t, filepath.Join(dir, "version.json"))), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions["app"].Name != "1.3.0" || versions["wear"].Name != "1.2.4" || versions["wear"].Code != 41 {
+		t.Errorf("version JSON file = %v, want app 1.3.0 and wear 1.2.4 (41)", versions)
+	}
+
+	plan := filepath.Join(dir, "plan.json")
+	out, err = runStep(t, map[string]string{"mode": "plan", "module_bump_types": "app=minor,wear=patch", "plan_output_path": plan})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	var plans map[string]Summary
+	if err := json.Unmarshal([]byte(readFixture(t, plan)), &plans); err != nil {
+		t.Fatal(err)
+	}
+	if plans["app"].File != "app/build.gradle" || plans["app"].New.Name != "1.3.0" || plans["wear"].New.Name != "1.2.4" {
+		t.Errorf("plan = %+v, want the app and wear plans", plans)
+	}
+}
+
+func TestCodeOnlyBumpExports(t *testing.T) {
+	for _, test := range []struct {
+		exportUnchanged string
+		want            map[string]string
+	}{
+		{"true", map[string]string{"BUMP_VERSION_CODE": "13", "BUMP_VERSION_NAME": "1.2.3"}},
+		{"false", map[string]string{"BUMP_VERSION_CODE": "13"}},
+	} {
+		exports := fakeEnvman(t)
+		configs := testConfigs(t, map[string]string{"bump_type": "none", "export_unchanged_version_name": test.exportUnchanged})
+
+		old := Versions{Name: "1.2.3", Code: 12}
+		emitVersions(versionEmitters(configs, "", old, map[string]Versions{}), Versions{Name: "1.2.3", Code: 13})
+
+		got := exports()
+		if len(got) != len(test.want) {
+			t.Errorf("exported %v with export_unchanged_version_name %s, want %v", got, test.exportUnchanged, test.want)
+		}
+		for key, value := range test.want {
+			if got[key] != value {
+				t.Errorf("%s = %q with export_unchanged_version_name %s, want %q", key, got[key], test.exportUnchanged, value)
+			}
+		}
+	}
+}
+
+func TestExportEnvmanFalseNeverRunsEnvman(t *testing.T) {
+	newTestRepo(t)
+	addTestRemote(t, "origin")
+	// a failing envman fails the step if it is run at all
+	calls := flakyEnvman(t, 100)
+
+	out, err := runStep(t, map[string]string{"export_envman": "false", "do_merge": "false", "code_tag_template": "build-{version_code}"})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+	if got := calls(); got != 0 {
+		t.Errorf("envman called %d times with export_envman false", got)
+	}
+}
+
+func TestEveryEmitterInOneRun(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+	dir := t.TempDir()
+
+	out, err := runStep(t, map[string]string{
+		"mode":                "export_only",
+		"version_output_file": filepath.Join(dir, "version.env"),
+		"version_json_file":   filepath.Join(dir, "version.json"),
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if got := exports(); got["BUMP_VERSION_NAME"] != "1.2.4" || got["BUMP_VERSION_CODE"] != "13" {
+		t.Errorf("envman exports = %v", got)
+	}
+	if content := readFixture(t, filepath.Join(dir, "version.env")); content != "BUMP_VERSION_NAME=1.2.4\nBUMP_VERSION_CODE=13\n" {
+		t.Errorf("version output file = %q", content)
+	}
+	var versions Versions
+	if err := json.Unmarshal([]byte(readFixture(t, filepath.Join(dir, "version.json"))), &versions); err != nil || versions.Name != "1.2.4" || versions.Code != 13 {
+		t.Errorf("version JSON file = %+v, %v", versions, err)
+	}
+}
+
+func TestVersionEmittersGatedByInputs(t *testing.T) {
+	for _, test := range []struct {
+		overrides    map[string]string
+		gitHubOutput string
+		want         []string
+	}{
+		{nil, "", []string{"outputs"}},
+		{map[string]string{"export_envman": "false"}, "", []string{}},
+		{map[string]string{"export_envman": "false"}, "github_output", []string{"outputs"}},
+		{map[string]string{"export_envman": "false", "github_output": "false"}, "github_output", []string{}},
+		{map[string]string{"version_output_file": "version.env", "version_json_file": "version.json"}, "", []string{"outputs", "version.env", "version.json"}},
+		{map[string]string{"export_envman": "false", "version_json_file": "version.json"}, "", []string{"version.json"}},
+	} {
+		configs := testConfigs(t, test.overrides)
+		t.Setenv("GITHUB_OUTPUT", test.gitHubOutput)
+
+		names := []string{}
+		for _, emitter := range versionEmitters(configs, "", Versions{}, map[string]Versions{}) {
+			names = append(names, emitter.Name)
+		}
+		if !equalStrings(names, test.want) {
+			t.Errorf("versionEmitters() with %v = %v, want %v", test.overrides, names, test.want)
+		}
+	}
+}
+
+func TestSingleFileJSONIsTheVersionsObject(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "version.json")
+
+	if err := writeVersionJSONFile(file, map[string]Versions{"": {Name: "1.2.4", Code: 13}}); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFixture(t, file); content != "{\n  \"code\": 13,\n  \"name\": \"1.2.4\"\n}\n" {
+		t.Errorf("version JSON file = %q", content)
+	}
+}
+
+func TestBumpWithoutVersionName(t *testing.T) {
+	newTestRepo(t)
+	writeFixture(t, "app/build.gradle", "android {\n    defaultConfig {\n        versionCode 12\n    }\n}\n")
+	runGit(t, "commit", "-q", "-am", "Library module")
+	addTestRemote(t, "origin")
+	exports := fakeEnvman(t)
+
+	out, err := runStep(t, map[string]string{
+		"require_version_name": "false",
+		"do_merge":             "false",
+		"commit_message":       "Bump versionCode to {version_code}",
+	})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	if content := readFixture(t, "app/build.gradle"); content != "android {\n    defaultConfig {\n        versionCode 13\n    }\n}\n" {
+		t.Errorf("app/build.gradle = %q, want only versionCode bumped", content)
+	}
+	if subject := runGit(t, "log", "-1", "--format=%s"); subject != "Bump versionCode to 13" {
+		t.Errorf("commit subject = %q", subject)
+	}
+	if tags := runGit(t, "tag"); tags != "" {
+		t.Errorf("tags = %q, want no release tag", tags)
+	}
+
+	got := exports()
+	if got["BUMP_VERSION_CODE"] != "13" {
+		t.Errorf("BUMP_VERSION_CODE = %q, want 13", got["BUMP_VERSION_CODE"])
+	}
+	if name, ok := got["BUMP_VERSION_NAME"]; ok && name != "" {
+		t.Errorf("BUMP_VERSION_NAME = %q, want it not exported", name)
+	}
+	if _, ok := got["BUMP_TAG_NAME"]; ok {
+		t.Errorf("BUMP_TAG_NAME exported without a versionName")
+	}
+}
+
+func TestOutputKeyCollision(t *testing.T) {
+	for _, test := range []struct {
+		keys    []string
+		modules []string
+		err     string
+	}{
+		{outputKeys, []string{"app", "feature:wear", "wear"}, ""},
+		{outputKeys, []string{"feature-wear", "feature:wear"}, "Modules feature-wear and feature:wear both export BUMP_VERSION_NAME_FEATURE_WEAR"},
+		{outputKeys, []string{"wear", "WEAR"}, "Modules wear and WEAR both export BUMP_VERSION_NAME_WEAR"},
+		{outputKeys, []string{"app", ":"}, "Module : has no letters or digits"},
+		{outputKeys, []string{"--"}, "Module -- has no letters or digits"},
+		// BUMP_VERSION qualified with the module name is the unqualified BUMP_VERSION_NAME
+		{[]string{"BUMP_VERSION_NAME", "BUMP_VERSION"}, []string{"name"}, "Module name exports BUMP_VERSION_NAME, which is reserved for the unqualified output"},
+	} {
+		err := outputKeyCollision(test.keys, test.modules)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("outputKeyCollision(%v) = %s", test.modules, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("outputKeyCollision(%v) error = %v, want %q", test.modules, err, test.err)
+		}
+	}
+}
+
+func TestModuleBumpTypesOutputCollisionValidation(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "feature/wear/build.gradle", buildGradleFixture)
+
+	_, err := testConfigs(t, map[string]string{"module_bump_types": "feature:wear=patch,feature-wear=minor"}).validate()
+	if err == nil || err.Error() != "Colliding module outputs!" {
+		t.Errorf("validate() error = %v, want the colliding module outputs", err)
+	}
+	if _, err := testConfigs(t, map[string]string{"module_bump_types": "feature:wear=patch"}).validate(); err != nil {
+		t.Errorf("validate() with one module = %s", err)
+	}
+}
+
+func TestAppendGitHubOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "github_output")
+	for _, output := range [][2]string{
+		{"BUMP_VERSION_NAME", "1.2.4"},
+		{"BUMP_MATCHED_FILES", "./app/build.gradle\n./wear/build.gradle"},
+		{"BUMP_COMMIT_MESSAGE", "Bump\nBUMP_EOF\nagain"},
+	} {
+		if err := appendGitHubOutput(file, output[0], output[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "BUMP_VERSION_NAME=1.2.4\n" +
+		"BUMP_MATCHED_FILES<<BUMP_EOF\n./app/build.gradle\n./wear/build.gradle\nBUMP_EOF\n" +
+		"BUMP_COMMIT_MESSAGE<<BUMP_EOF_\nBump\nBUMP_EOF\nagain\nBUMP_EOF_\n"
+	if content := readFixture(t, file); content != want {
+		t.Errorf("GitHub output =\n%s\nwant\n%s", content, want)
+	}
+}
+
+func TestGitHubOutputWithoutEnvman(t *testing.T) {
+	newTestRepo(t)
+	exports := fakeEnvman(t)
+	file := filepath.Join(t.TempDir(), "github_output")
+
+	out, err := runStep(t, map[string]string{"mode": "export_only", "export_envman": "false", "list_matches": "true", "GITHUB_OUTPUT": file})
+	if err != nil {
+		t.Fatalf("step failed: %s\n%s", err, out)
+	}
+
+	want := "BUMP_MATCHED_FILES=./app/build.gradle\nBUMP_VERSION_CODE=13\nBUMP_VERSION_NAME=1.2.4\n"
+	if content := readFixture(t, file); content != want {
+		t.Errorf("GitHub output =\n%s\nwant\n%s", content, want)
+	}
+	if got := exports(); len(got) > 0 {
+		t.Errorf("exported %v with envman, want nothing", got)
+	}
+}
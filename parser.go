@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// VersionParser parses, bumps and formats versionName values of a single format.
+type VersionParser interface {
+	Parse(name string) (*semver.Version, error)
+	Bump(version *semver.Version, bumpType string) error
+	// Format returns the name written back to the file, original is the name before the bump.
+	Format(version *semver.Version, original string) string
+}
+
+var versionParsers = map[string]func(configs ConfigsModel) VersionParser{
+	"semver": func(configs ConfigsModel) VersionParser {
+		return semverParser{preserveComponentCount: configs.PreserveComponentCount == "true"}
+	},
+	"v-semver": func(configs ConfigsModel) VersionParser {
+		return prefixedParser{prefix: "v", parser: semverParser{preserveComponentCount: configs.PreserveComponentCount == "true"}}
+	},
+}
+
+func versionParserFor(configs ConfigsModel) VersionParser {
+	if newParser, ok := versionParsers[configs.VersionFormat]; ok {
+		return newParser(configs)
+	}
+
+	return versionParsers["semver"](configs)
+}
+
+// splitVersionName splits a name into its dotted core and the pre-release/metadata rest, e.g. `1.2` and `-rc.1`.
+func splitVersionName(name string) (string, string) {
+	if index := strings.IndexAny(name, "-+"); index != -1 {
+		return name[:index], name[index:]
+	}
+
+	return name, ""
+}
+
+func versionComponentCount(name string) int {
+	core, _ := splitVersionName(name)
+	return len(strings.Split(core, "."))
+}
+
+// padVersionName pads short names like `1.2` to the three components semver requires.
+func padVersionName(name string) string {
+	core, rest := splitVersionName(name)
+	for count := versionComponentCount(name); count < 3; count++ {
+		core += ".0"
+	}
+
+	return core + rest
+}
+
+// trimVersionName drops trailing zero components down to count, e.g. `1.3.0` to `1.3`.
+func trimVersionName(name string, count int) string {
+	core, rest := splitVersionName(name)
+	components := strings.Split(core, ".")
+	for len(components) > count && components[len(components)-1] == "0" {
+		components = components[:len(components)-1]
+	}
+
+	return strings.Join(components, ".") + rest
+}
+
+type semverParser struct {
+	preserveComponentCount bool
+}
+
+func (parser semverParser) Parse(name string) (*semver.Version, error) {
+	return semver.NewVersion(padVersionName(name))
+}
+
+func (parser semverParser) Bump(version *semver.Version, bumpType string) error {
+	switch bumpType {
+	case "major":
+		version.BumpMajor()
+	case "minor":
+		version.BumpMinor()
+	case "patch":
+		version.BumpPatch()
+	case "prerelease-increment":
+		preRelease, err := incrementPreRelease(string(version.PreRelease))
+		if err != nil {
+			return err
+		}
+		version.PreRelease = semver.PreRelease(preRelease)
+	case "metadata-increment":
+		metadata, err := incrementMetadata(version.Metadata)
+		if err != nil {
+			return err
+		}
+		version.Metadata = metadata
+	case "finalize":
+		// a release is left as is, only its versionCode is bumped
+		version.PreRelease = ""
+	case "beta":
+		return bumpBeta(version)
+	case "release":
+		if !isBetaPreRelease(string(version.PreRelease)) {
+			return fmt.Errorf("Version %s is not a beta, there is nothing to release", version)
+		}
+		version.PreRelease = ""
+		version.Metadata = ""
+	default:
+	}
+
+	return nil
+}
+
+const betaPreRelease = "beta"
+
+func isBetaPreRelease(preRelease string) bool {
+	return preRelease == betaPreRelease || strings.HasPrefix(preRelease, betaPreRelease+".")
+}
+
+// bumpBeta starts a beta series of the next patch, e.g. `1.2.3` to `1.2.4-beta.1`,
+// or continues the current one, e.g. `1.2.4-beta.1` to `1.2.4-beta.2`.
+func bumpBeta(version *semver.Version) error {
+	preRelease := string(version.PreRelease)
+	switch {
+	case preRelease == "":
+		version.BumpPatch()
+		version.PreRelease = betaPreRelease + ".1"
+	case preRelease == betaPreRelease:
+		version.PreRelease = betaPreRelease + ".1"
+	case isBetaPreRelease(preRelease):
+		next, err := incrementPreRelease(preRelease)
+		if err != nil {
+			return err
+		}
+		version.PreRelease = semver.PreRelease(next)
+	default:
+		// e.g. rc.1 is ordered after any beta of the same version
+		return fmt.Errorf("Version %s is a %s pre-release, a beta would be older", version, preRelease)
+	}
+
+	return nil
+}
+
+// incrementPreRelease increments the trailing numeric identifier, e.g. `beta.4` to `beta.5`.
+func incrementPreRelease(preRelease string) (string, error) {
+	if preRelease == "" {
+		return "", fmt.Errorf("Version has no pre-release to increment")
+	}
+
+	identifiers := strings.Split(preRelease, ".")
+	last := len(identifiers) - 1
+	number, err := strconv.ParseInt(identifiers[last], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Pre-release %s has no trailing numeric identifier to increment, e.g. %s.1", preRelease, preRelease)
+	}
+	identifiers[last] = strconv.FormatInt(number+1, 10)
+
+	return strings.Join(identifiers, "."), nil
+}
+
+// incrementMetadata increments build metadata used as a counter, e.g. `41` to `42`.
+func incrementMetadata(metadata string) (string, error) {
+	if metadata == "" {
+		return "", fmt.Errorf("Version has no build metadata to increment")
+	}
+	if strings.Trim(metadata, "0123456789") != "" {
+		return "", fmt.Errorf("Build metadata %s must be a number to be incremented, e.g. 41", metadata)
+	}
+
+	number, err := strconv.ParseInt(metadata, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(number+1, 10), nil
+}
+
+func (parser semverParser) Format(version *semver.Version, original string) string {
+	// String() re-emits the canonical form, e.g. `1.2.3` for `1.02.3`, an unchanged name is kept as written
+	// so a bump that doesn't change the versionName doesn't rewrite it either
+	if parsed, err := parser.Parse(original); err == nil && isSameVersion(*parsed, *version) {
+		return original
+	}
+
+	name := version.String()
+	if parser.preserveComponentCount {
+		name = trimVersionName(name, versionComponentCount(original))
+	}
+
+	return name
+}
+
+// isSameVersion compares every component, unlike semver's Equal, which ignores the build metadata.
+func isSameVersion(a, b semver.Version) bool {
+	return a.Major == b.Major && a.Minor == b.Minor && a.Patch == b.Patch && a.PreRelease == b.PreRelease && a.Metadata == b.Metadata
+}
+
+// prefixedParser handles names with a fixed prefix, e.g. `v1.2.3`.
+type prefixedParser struct {
+	prefix string
+	parser VersionParser
+}
+
+func (parser prefixedParser) Parse(name string) (*semver.Version, error) {
+	return parser.parser.Parse(strings.TrimPrefix(name, parser.prefix))
+}
+
+func (parser prefixedParser) Bump(version *semver.Version, bumpType string) error {
+	return parser.parser.Bump(version, bumpType)
+}
+
+func (parser prefixedParser) Format(version *semver.Version, original string) string {
+	return parser.prefix + parser.parser.Format(version, strings.TrimPrefix(original, parser.prefix))
+}
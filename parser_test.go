@@ -0,0 +1,229 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// bumpName bumps the name with the parser of the configs, failing the test on a parse error.
+func bumpName(t *testing.T, configs ConfigsModel, name, bumpType string) (string, error) {
+	t.Helper()
+
+	parser := versionParserFor(configs)
+	version, err := parser.Parse(name)
+	if err != nil {
+		t.Fatalf("Parse(%s) = %s", name, err)
+	}
+	if err := parser.Bump(version, bumpType); err != nil {
+		return "", err
+	}
+
+	return parser.Format(version, name), nil
+}
+
+func TestBumpPreReleaseIncrement(t *testing.T) {
+	configs := testConfigs(t, nil)
+	for _, test := range []struct {
+		name string
+		want string
+		err  string
+	}{
+		{"1.2.3-beta.4", "1.2.3-beta.5", ""},
+		{"1.2.3-beta.9", "1.2.3-beta.10", ""},
+		{"1.2.3-rc.1+build.7", "1.2.3-rc.2+build.7", ""},
+		{"1.2.3-1", "1.2.3-2", ""},
+		{"1.2.3-rc", "", "no trailing numeric identifier"},
+		{"1.2.3", "", "no pre-release"},
+	} {
+		bumped, err := bumpName(t, configs, test.name, "prerelease-increment")
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("prerelease-increment of %s error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("prerelease-increment of %s = %s", test.name, err)
+		} else if bumped != test.want {
+			t.Errorf("prerelease-increment of %s = %s, want %s", test.name, bumped, test.want)
+		}
+	}
+}
+
+func TestPreserveComponentCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		bumpType string
+		preserve string
+		want     string
+	}{
+		{"1.2", "patch", "false", "1.2.1"},
+		{"1.2", "minor", "false", "1.3.0"},
+		{"1.2", "minor", "true", "1.3"},
+		{"1.2", "patch", "true", "1.2.1"},
+		{"1", "major", "true", "2"},
+		{"1.2-beta", "minor", "true", "1.3"},
+		{"1.2.3", "minor", "true", "1.3.0"},
+	}
+
+	for _, test := range tests {
+		configs := testConfigs(t, map[string]string{"preserve_component_count": test.preserve})
+		name, err := bumpName(t, configs, test.name, test.bumpType)
+		if err != nil {
+			t.Errorf("%s bump of %s = %s", test.bumpType, test.name, err)
+		} else if name != test.want {
+			t.Errorf("%s bump of %s (preserve %s) = %s, want %s", test.bumpType, test.name, test.preserve, name, test.want)
+		}
+	}
+}
+
+func TestTrimVersionName(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  string
+	}{
+		{"1.3.0", 2, "1.3"},
+		{"2.0.0", 1, "2"},
+		{"1.3.1", 2, "1.3.1"},
+		{"1.0.0-rc.1", 2, "1.0-rc.1"},
+		{"1.3.0", 3, "1.3.0"},
+	}
+
+	for _, test := range tests {
+		if name := trimVersionName(test.name, test.count); name != test.want {
+			t.Errorf("trimVersionName(%s, %d) = %s, want %s", test.name, test.count, name, test.want)
+		}
+	}
+}
+
+func TestBetaReleaseProgression(t *testing.T) {
+	configs := testConfigs(t, nil)
+
+	// each bump starts from the name the previous one wrote
+	name := "1.2.3"
+	for _, test := range []struct {
+		bumpType string
+		want     string
+	}{
+		{"beta", "1.2.4-beta.1"},
+		{"beta", "1.2.4-beta.2"},
+		{"beta", "1.2.4-beta.3"},
+		{"release", "1.2.4"},
+		{"beta", "1.2.5-beta.1"},
+	} {
+		bumped, err := bumpName(t, configs, name, test.bumpType)
+		if err != nil {
+			t.Fatalf("%s bump of %s = %s", test.bumpType, name, err)
+		}
+		if bumped != test.want {
+			t.Errorf("%s bump of %s = %s, want %s", test.bumpType, name, bumped, test.want)
+		}
+		name = bumped
+	}
+}
+
+func TestBetaReleaseErrors(t *testing.T) {
+	configs := testConfigs(t, nil)
+	for _, test := range []struct {
+		name     string
+		bumpType string
+		want     string
+		err      string
+	}{
+		{"1.2.4-beta", "beta", "1.2.4-beta.1", ""},
+		{"1.2.4-rc.1", "beta", "", "a beta would be older"},
+		{"1.2.4", "release", "", "is not a beta"},
+		{"1.2.4-rc.1", "release", "", "is not a beta"},
+	} {
+		bumped, err := bumpName(t, configs, test.name, test.bumpType)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s bump of %s error = %v, want %q", test.bumpType, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || bumped != test.want {
+			t.Errorf("%s bump of %s = %s, %v, want %s", test.bumpType, test.name, bumped, err, test.want)
+		}
+	}
+}
+
+func TestBumpFinalize(t *testing.T) {
+	configs := testConfigs(t, nil)
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"1.2.3-rc.2", "1.2.3"},
+		{"1.2.3-beta.1+build.7", "1.2.3+build.7"},
+		{"1.2.3", "1.2.3"},
+		{"1.02.3", "1.02.3"},
+	} {
+		bumped, err := bumpName(t, configs, test.name, "finalize")
+		if err != nil || bumped != test.want {
+			t.Errorf("finalize of %s = %s, %v, want %s", test.name, bumped, err, test.want)
+		}
+	}
+
+	// the versionCode is still bumped when the versionName already is a release
+	bumped, err := bumpVersions(testConfigs(t, map[string]string{"bump_type": "finalize"}), Versions{Name: "1.2.3", Code: 12})
+	if err != nil || bumped.Name != "1.2.3" || bumped.Code != 13 {
+		t.Errorf("bumpVersions() with finalize = %s (%d), %v, want 1.2.3 (13)", bumped.Name, bumped.Code, err)
+	}
+}
+
+func TestFormatKeepsNonCanonicalName(t *testing.T) {
+	configs := testConfigs(t, nil)
+	if version, err := versionParserFor(configs).Parse("1.02.3"); err != nil || version.String() == "1.02.3" {
+		t.Fatalf("Parse(1.02.3) = %v, %v, want a canonical form differing from the source", version, err)
+	}
+	for _, test := range []struct {
+		name     string
+		bumpType string
+		want     string
+	}{
+		{"1.02.3", "none", "1.02.3"},
+		{"01.2.3-rc.1", "none", "01.2.3-rc.1"},
+		{"1.02.3", "patch", "1.2.4"},
+		{"1.2.3", "none", "1.2.3"},
+	} {
+		if bumped, err := bumpName(t, configs, test.name, test.bumpType); err != nil || bumped != test.want {
+			t.Errorf("%s bump of %s = %s, %v, want %s", test.bumpType, test.name, bumped, err, test.want)
+		}
+	}
+}
+
+func TestBumpMetadataIncrement(t *testing.T) {
+	configs := testConfigs(t, nil)
+	for _, test := range []struct {
+		name string
+		want string
+		err  string
+	}{
+		{"1.2.3+41", "1.2.3+42", ""},
+		{"1.2.3+9", "1.2.3+10", ""},
+		{"1.2.3-rc.1+41", "1.2.3-rc.1+42", ""},
+		{"1.2.3+build.41", "", "must be a number"},
+		{"1.2.3+41a", "", "must be a number"},
+		{"1.2.3", "", "no build metadata"},
+	} {
+		bumped, err := bumpName(t, configs, test.name, "metadata-increment")
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("metadata-increment of %s error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || bumped != test.want {
+			t.Errorf("metadata-increment of %s = %s, %v, want %s", test.name, bumped, err, test.want)
+		}
+	}
+
+	// build_metadata_env doesn't replace the incremented counter
+	t.Setenv("BITRISE_BUILD_NUMBER", "7")
+	configs = testConfigs(t, map[string]string{"bump_type": "metadata-increment", "build_metadata_env": "BITRISE_BUILD_NUMBER"})
+	if bumped, err := bumpVersionName(configs, "1.2.3+41"); err != nil || bumped != "1.2.3+42" {
+		t.Errorf("bumpVersionName(1.2.3+41) = %s, %v, want 1.2.3+42", bumped, err)
+	}
+}
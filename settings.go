@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Errors of resolving a module's build file.
+var (
+	ErrModuleNotIncluded  = errors.New("Module is not included in `settings.gradle` or `settings.gradle.kts`")
+	ErrModuleFileNotExist = errors.New("Module has no `build.gradle` file")
+)
+
+// settingsFiles are the settings files the modules are read from, the Groovy one wins if both exist.
+var settingsFiles = []string{"settings.gradle", "settings.gradle.kts"}
+
+var (
+	// an include lists its modules in parentheses, which may span lines, or up to a line not ending with a comma
+	settingsIncludeRegexp    = regexp.MustCompile(`(?m)^\s*include\b(\s*\([^)]*\)|(?:.*,[ \t]*\r?\n)*.*)`)
+	settingsQuotedRegexp     = regexp.MustCompile(`['"]([^'"]+)['"]`)
+	settingsProjectDirRegexp = regexp.MustCompile(`project\s*\(\s*['"]([^'"]+)['"]\s*\)\s*\.projectDir\s*=\s*(?:new\s+File|File|file)\s*\(\s*(?:(?:settingsDir|rootDir|rootProject\.projectDir)\s*,\s*)?['"]([^'"]+)['"]`)
+)
+
+// Settings holds the project path mapping parsed from a `settings.gradle` or `settings.gradle.kts` file,
+// included builds (`includeBuild`) aren't modules of it.
+type Settings struct {
+	Includes    []string
+	ProjectDirs map[string]string
+}
+
+func normalizeProjectPath(module string) string {
+	return ":" + strings.TrimPrefix(strings.TrimSpace(module), ":")
+}
+
+func parseSettings(content string) Settings {
+	settings := Settings{
+		Includes:    []string{},
+		ProjectDirs: map[string]string{},
+	}
+
+	for _, include := range settingsIncludeRegexp.FindAllStringSubmatch(content, -1) {
+		for _, quoted := range settingsQuotedRegexp.FindAllStringSubmatch(include[1], -1) {
+			settings.Includes = append(settings.Includes, normalizeProjectPath(quoted[1]))
+		}
+	}
+
+	for _, projectDir := range settingsProjectDirRegexp.FindAllStringSubmatch(content, -1) {
+		settings.ProjectDirs[normalizeProjectPath(projectDir[1])] = projectDir[2]
+	}
+
+	return settings
+}
+
+func (settings Settings) isIncluded(projectPath string) bool {
+	for _, include := range settings.Includes {
+		if include == projectPath {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (settings Settings) projectDir(projectPath string) string {
+	if dir, ok := settings.ProjectDirs[projectPath]; ok {
+		return dir
+	}
+
+	return strings.Replace(strings.TrimPrefix(projectPath, ":"), ":", "/", -1)
+}
+
+func findModuleBuildGradleFile(rootDir, module string) (string, error) {
+	projectPath := normalizeProjectPath(module)
+	settings := Settings{ProjectDirs: map[string]string{}}
+
+	for _, name := range settingsFiles {
+		settingsFile := filepath.Join(rootDir, name)
+		if exist, err := pathutil.IsPathExists(settingsFile); err != nil {
+			return "", err
+		} else if !exist {
+			continue
+		}
+
+		bytes, err := ioutil.ReadFile(settingsFile)
+		if err != nil {
+			return "", err
+		}
+
+		settings = parseSettings(string(bytes))
+		if !settings.isIncluded(projectPath) {
+			return "", ErrModuleNotIncluded
+		}
+		break
+	}
+
+	file := filepath.Join(rootDir, settings.projectDir(projectPath), "build.gradle")
+	if exist, err := pathutil.IsPathExists(file); err != nil {
+		return "", err
+	} else if !exist {
+		return "", ErrModuleFileNotExist
+	}
+
+	return file, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindModuleBuildGradleFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		settings string
+		module   string
+		want     string
+		err      error
+	}{
+		{"no settings", "settings.gradle", "", "app", "app/build.gradle", nil},
+		{"included", "settings.gradle", "include ':app', ':wear'\n", "wear", "wear/build.gradle", nil},
+		{"included with colon", "settings.gradle", "include \":app\"\n", ":app", "app/build.gradle", nil},
+		{"nested", "settings.gradle", "include ':feature:login'\n", ":feature:login", "feature/login/build.gradle", nil},
+		{"project dir", "settings.gradle", "include ':wear'\nproject(':wear').projectDir = new File(settingsDir, 'modules/watch')\n", "wear", "modules/watch/build.gradle", nil},
+		{"project dir file", "settings.gradle", "include ':wear'\nproject(':wear').projectDir = file('modules/watch')\n", "wear", "modules/watch/build.gradle", nil},
+		{"not included", "settings.gradle", "include ':app'\n", "wear", "", ErrModuleNotIncluded},
+		{"commented out", "settings.gradle", "include ':app'\n// include ':wear'\n", "wear", "", ErrModuleNotIncluded},
+		{"missing build file", "settings.gradle", "include ':tv'\n", "tv", "", ErrModuleFileNotExist},
+		{"multi-line include", "settings.gradle", "include ':app',\n        ':wear'\n", "wear", "wear/build.gradle", nil},
+		{"kotlin", "settings.gradle.kts", "include(\":app\", \":wear\")\n", "wear", "wear/build.gradle", nil},
+		{"kotlin multi-line include", "settings.gradle.kts", "include(\n    \":app\",\n    \":feature:login\",\n)\n", ":feature:login", "feature/login/build.gradle", nil},
+		{"kotlin project dir", "settings.gradle.kts", "include(\":wear\")\nproject(\":wear\").projectDir = File(rootDir, \"modules/watch\")\n", "wear", "modules/watch/build.gradle", nil},
+		{"kotlin not included", "settings.gradle.kts", "include(\":app\")\n", "wear", "", ErrModuleNotIncluded},
+		{"included build", "settings.gradle.kts", "includeBuild(\"wear\")\ninclude(\":app\")\n", "wear", "", ErrModuleNotIncluded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range []string{"app/build.gradle", "wear/build.gradle", "feature/login/build.gradle", "modules/watch/build.gradle"} {
+				writeFixture(t, filepath.Join(dir, file), buildGradleFixture)
+			}
+			if test.settings != "" {
+				writeFixture(t, filepath.Join(dir, test.file), test.settings)
+			}
+
+			file, err := findModuleBuildGradleFile(dir, test.module)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("findModuleBuildGradleFile(%s) error = %v, want %v", test.module, err, test.err)
+			}
+			if want := filepath.Join(dir, test.want); test.err == nil && file != want {
+				t.Errorf("findModuleBuildGradleFile(%s) = %s, want %s", test.module, file, want)
+			}
+		})
+	}
+}
@@ -12,4 +12,6 @@ rsync -avh --quiet "${THIS_SCRIPT_DIR}/" "${full_package_path}/"
 
 export GOPATH="${tmp_gopath_dir}"
 export GO15VENDOREXPERIMENT=1
-go run "${full_package_path}/main.go"
+# the step is built from GOPATH with the vendored deps, it has no go.mod (Go 1.13 or later is needed)
+export GO111MODULE=off
+go run "${full_package_path}"
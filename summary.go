@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+// runResult collects what a bump of one file did, for the summary at the end of the run.
+type runResult struct {
+	File         string
+	Module       string
+	Old          Versions
+	New          Versions
+	CommitSHA    string
+	Tags         []string
+	PushedBranch bool
+	PushedTags   bool
+}
+
+// summaryLines formats the results as `key: value` lines, one block per bumped file.
+func summaryLines(results []runResult) []string {
+	lines := []string{}
+	for _, result := range results {
+		if result.Module != "" {
+			lines = append(lines, "module: "+result.Module)
+		}
+		lines = append(lines, "file: "+result.File)
+		if result.Old.Name != "" || result.New.Name != "" {
+			lines = append(lines, fmt.Sprintf("versionName: %s -> %s", result.Old.Name, result.New.Name))
+		}
+		lines = append(lines, fmt.Sprintf("versionCode: %d -> %d", result.Old.Code, result.New.Code))
+		lines = append(lines, "commit: "+valueOrNone(result.CommitSHA))
+		lines = append(lines, "tags: "+valueOrNone(strings.Join(result.Tags, ", ")))
+		lines = append(lines, "pushed branch: "+yesNo(result.PushedBranch))
+		lines = append(lines, "pushed tags: "+yesNo(result.PushedTags))
+	}
+
+	return lines
+}
+
+func printRunSummary(results []runResult) {
+	log.Info("Summary:")
+	for _, line := range summaryLines(results) {
+		log.Detail("%s", line)
+	}
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+
+	return value
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSummaryLines(t *testing.T) {
+	lines := summaryLines([]runResult{
+		{
+			File:         "app/build.gradle",
+			Old:          Versions{Name: "1.2.3", Code: 12},
+			New:          Versions{Name: "1.2.4", Code: 13},
+			CommitSHA:    "0123abc",
+			Tags:         []string{"v1.2.4", "release"},
+			PushedBranch: true,
+			PushedTags:   true,
+		},
+		{
+			File:   "wear/build.gradle",
+			Module: "wear",
+			Old:    Versions{Code: 7},
+			New:    Versions{Code: 8},
+		},
+	})
+
+	want := []string{
+		"file: app/build.gradle",
+		"versionName: 1.2.3 -> 1.2.4",
+		"versionCode: 12 -> 13",
+		"commit: 0123abc",
+		"tags: v1.2.4, release",
+		"pushed branch: yes",
+		"pushed tags: yes",
+		"module: wear",
+		"file: wear/build.gradle",
+		"versionCode: 7 -> 8",
+		"commit: none",
+		"tags: none",
+		"pushed branch: no",
+		"pushed tags: no",
+	}
+	if !equalStrings(lines, want) {
+		t.Errorf("summaryLines() =\n%q\nwant\n%q", lines, want)
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/thefuntasty/bitrise-step-bump-android/logger"
+)
+
+// gradleSeparator is the whitespace, newlines and comments allowed between a property and its value.
+const gradleSeparator = `(?:\s|/\*[\s\S]*?\*/|//[^\n]*\n)+`
+
+var (
+	versionNameRegexp        = regexp.MustCompile(`versionName` + gradleSeparator + `"([0-9A-Za-z.+-]+)"`)
+	versionCodeRegexp        = regexp.MustCompile(`versionCode` + gradleSeparator + `(\d+)`)
+	pubspecVersionNameRegexp = regexp.MustCompile(`(?m)^version:\s*["']?([0-9A-Za-z.-]+)\+\d+`)
+	pubspecVersionCodeRegexp = regexp.MustCompile(`(?m)^version:\s*["']?[0-9A-Za-z.-]+\+(\d+)`)
+
+	// versionNameConcatenationRegexp matches `versionName "1.2." + patch` and `versionName base + ".3"`,
+	// both it and versionNameConditionalRegexp only match a declaration starting its line, not a comment.
+	versionNameConcatenationRegexp = regexp.MustCompile(`(?m)^[ \t]*versionName\s+(?:"[^"\n]*"|[A-Za-z_][\w.]*)\s*\+`)
+	versionNameConditionalRegexp   = regexp.MustCompile(`(?m)^[ \t]*versionName\s+[^"\n?/]+\?`)
+	versionNameVariableRegexp      = regexp.MustCompile(`versionName\s+(?:"\$\{?([A-Za-z_][\w.]*)\}?"|([A-Za-z_][\w.]*))`)
+	applyFromRegexp                = regexp.MustCompile(`apply\s+from\s*:\s*['"]([^'"]+)['"]`)
+)
+
+// readVersionFile reads the files the versions are read from, the working tree unless mode read_ref
+// replaces it with a reader of a git ref. Files are always written to the working tree.
+var readVersionFile = ioutil.ReadFile
+
+// versionField is the place a version value is read from and written to,
+// matched by Regexp or, in XML files, located by decoding the file.
+type versionField struct {
+	File   string
+	Regexp *regexp.Regexp
+	XML    *xmlField
+}
+
+func (field versionField) read() (string, error) {
+	bytes, err := readVersionFile(field.File)
+	if err != nil {
+		return "", err
+	}
+
+	if field.XML != nil {
+		start, end, err := field.XML.locate(string(bytes))
+		if err != nil {
+			return "", fmt.Errorf("Failed to locate %s in %s: %s", field.XML, field.File, err)
+		}
+		return string(bytes)[start:end], nil
+	}
+
+	matches := field.Regexp.FindStringSubmatch(string(bytes))
+	if len(matches) < 2 {
+		return "", fmt.Errorf("Failed to match %s in %s", field.Regexp, field.File)
+	}
+
+	return matches[1], nil
+}
+
+func isPropertiesFile(file string) bool {
+	return filepath.Ext(file) == ".properties"
+}
+
+// isPubspecFile matches Flutter's pubspec.yaml, whose `version: 1.2.3+45` holds the versionName and versionCode.
+func isPubspecFile(file string) bool {
+	return filepath.Ext(file) == ".yaml" || filepath.Ext(file) == ".yml"
+}
+
+// propertiesRegexp matches a `key=value` or `key: value` line, the key is e.g. `versionName` or `app.version`.
+func propertiesRegexp(key, value string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(key) + `\s*[=:]\s*` + value + `\s*$`)
+}
+
+func isKotlinFile(file string) bool {
+	return filepath.Ext(file) == ".kt"
+}
+
+// kotlinConstantRegexp matches `const val name = value`, optionally with a type, e.g. `const val versionCode: Int = 5`.
+func kotlinConstantRegexp(name, value string) *regexp.Regexp {
+	return regexp.MustCompile(`\bconst\s+val\s+` + regexp.QuoteMeta(name) + `\s*(?::\s*\w+\s*)?=\s*` + value)
+}
+
+func versionNameRegexpFor(configs ConfigsModel, file string) *regexp.Regexp {
+	if isKotlinFile(file) {
+		return kotlinConstantRegexp(configs.BuildSrcNameConstant, `"([0-9A-Za-z.+-]+)"`)
+	}
+	if isPubspecFile(file) {
+		return pubspecVersionNameRegexp
+	}
+	if isPropertiesFile(file) {
+		return propertiesRegexp(configs.PropertiesNameKey, `([0-9A-Za-z.+-]+)`)
+	}
+
+	return versionNameRegexp
+}
+
+func versionCodeRegexpFor(configs ConfigsModel, file string) *regexp.Regexp {
+	if isKotlinFile(file) {
+		return kotlinConstantRegexp(configs.BuildSrcCodeConstant, `(\d+)`)
+	}
+	if isPubspecFile(file) {
+		return pubspecVersionCodeRegexp
+	}
+	if isPropertiesFile(file) {
+		return propertiesRegexp(configs.PropertiesCodeKey, `(\d+)`)
+	}
+
+	return versionCodeRegexp
+}
+
+// variableDefinitionRegexp matches `def name = "1.2.3"`, `ext.name = "1.2.3"` and `name = "1.2.3"` in an ext block.
+func variableDefinitionRegexp(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)(?:^|[\s{;.])` + regexp.QuoteMeta(name) + `\s*=\s*["']([0-9A-Za-z.+-]+)["']`)
+}
+
+// variableName strips the scopes variables are commonly referenced with, e.g. `rootProject.ext.baseVersion`.
+func variableName(reference string) string {
+	for _, prefix := range []string{"rootProject.ext.", "project.ext.", "ext."} {
+		reference = strings.TrimPrefix(reference, prefix)
+	}
+
+	return reference
+}
+
+// appliedFiles lists the scripts applied to the file with `apply from: 'path'`.
+func appliedFiles(file, content string) []string {
+	files := []string{}
+	for _, match := range applyFromRegexp.FindAllStringSubmatch(content, -1) {
+		applied := match[1]
+		if !filepath.IsAbs(applied) {
+			applied = filepath.Join(filepath.Dir(file), applied)
+		}
+		files = append(files, applied)
+	}
+
+	return files
+}
+
+func locateVariable(file, content, name string) (versionField, error) {
+	re := variableDefinitionRegexp(name)
+	candidates := append([]string{file}, appliedFiles(file, content)...)
+	for _, candidate := range candidates {
+		bytes, err := readVersionFile(candidate)
+		if err != nil {
+			continue
+		}
+
+		if re.MatchString(string(bytes)) {
+			return versionField{File: candidate, Regexp: re}, nil
+		}
+	}
+
+	return versionField{}, fmt.Errorf("versionName refers to variable `%s`, but no `%s = \"X.Y.Z\"` definition was found in %s", name, name, strings.Join(candidates, ", "))
+}
+
+// conditionalVersionNameRegexp matches `versionName condition ? "1.2.3" : "1.2.3-dev"`, capturing the literal
+// of the selected branch only, so the other one is left as is.
+func conditionalVersionNameRegexp(branch string) *regexp.Regexp {
+	literal, other := `"([0-9A-Za-z.+-]+)"`, `"[0-9A-Za-z.+-]+"`
+	if branch == "false" {
+		literal, other = other, literal
+	}
+
+	return regexp.MustCompile(`(?m)^[ \t]*versionName\s+[^"\n?/]+\?\s*` + literal + `\s*:\s*` + other)
+}
+
+func locateConditionalVersionName(configs ConfigsModel, file, content string) (versionField, error) {
+	if configs.ConditionalBranch == "" {
+		return versionField{}, fmt.Errorf("%w, set conditional_branch to select the literal to bump", ErrVersionNameConditional)
+	}
+
+	re := conditionalVersionNameRegexp(configs.ConditionalBranch)
+	if !re.MatchString(content) {
+		return versionField{}, fmt.Errorf("%w, only `condition ? \"X.Y.Z\" : \"X.Y.Z\"` with two literals can be bumped", ErrVersionNameConditional)
+	}
+
+	return versionField{File: file, Regexp: re}, nil
+}
+
+// checkConsistentMatches fails if the matches of re have different values, e.g. a versionName
+// in two product flavors, as the bump would be based on the first one and overwrite the others.
+func checkConsistentMatches(file, content string, re *regexp.Regexp) error {
+	var values, locations []string
+	for _, indexes := range re.FindAllStringSubmatchIndex(content, -1) {
+		value := content[indexes[2]:indexes[3]]
+		line := strings.Count(content[:indexes[2]], "\n") + 1
+		values = append(values, value)
+		locations = append(locations, fmt.Sprintf("%q at %s:%d", value, file, line))
+	}
+
+	for _, value := range values[1:] {
+		if value != values[0] {
+			return fmt.Errorf("%w: %s", ErrVersionNameConflicting, strings.Join(locations, ", "))
+		}
+	}
+
+	return nil
+}
+
+func locateVersionName(configs ConfigsModel, file string) (versionField, error) {
+	bytes, err := readVersionFile(file)
+	if err != nil {
+		return versionField{}, err
+	}
+	content := string(bytes)
+
+	if isXMLFile(file) {
+		return locateXMLField(file, content, configs.XMLNameElement, configs.XMLNameAttribute, ErrVersionNameNotFound)
+	}
+
+	re := versionNameRegexpFor(configs, file)
+	if re == versionNameRegexp && versionNameConcatenationRegexp.MatchString(content) {
+		return versionField{}, ErrVersionNameConcatenated
+	}
+	if re == versionNameRegexp && versionNameConditionalRegexp.MatchString(content) {
+		return locateConditionalVersionName(configs, file, content)
+	}
+	if re.MatchString(content) {
+		if err := checkConsistentMatches(file, content, re); err != nil {
+			return versionField{}, err
+		}
+		return versionField{File: file, Regexp: re}, nil
+	}
+
+	if isPropertiesFile(file) || isKotlinFile(file) || isPubspecFile(file) {
+		return versionField{}, ErrVersionNameNotFound
+	}
+
+	matches := versionNameVariableRegexp.FindStringSubmatch(content)
+	if len(matches) != 3 {
+		return versionField{}, ErrVersionNameNotFound
+	}
+
+	reference := matches[1]
+	if reference == "" {
+		reference = matches[2]
+	}
+
+	return locateVariable(file, content, variableName(reference))
+}
+
+func locateVersionCode(configs ConfigsModel, file string) (versionField, error) {
+	bytes, err := readVersionFile(file)
+	if err != nil {
+		return versionField{}, err
+	}
+
+	if isXMLFile(file) {
+		return locateXMLField(file, string(bytes), configs.XMLCodeElement, configs.XMLCodeAttribute, ErrVersionCodeNotFound)
+	}
+
+	re := versionCodeRegexpFor(configs, file)
+	if !re.MatchString(string(bytes)) {
+		return versionField{}, ErrVersionCodeNotFound
+	}
+
+	return versionField{File: file, Regexp: re}, nil
+}
+
+// extraCodeRegexp matches a numeric property, e.g. `buildNumber 5`, `buildNumber = 5` or `buildNumber: 5`.
+func extraCodeRegexp(file, name string) *regexp.Regexp {
+	if isKotlinFile(file) {
+		return kotlinConstantRegexp(name, `(\d+)`)
+	}
+
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `(?:\s*[=:]\s*|` + gradleSeparator + `)(\d+)`)
+}
+
+func locateExtraCode(file, name string) (versionField, error) {
+	bytes, err := readVersionFile(file)
+	if err != nil {
+		return versionField{}, err
+	}
+
+	re := extraCodeRegexp(file, name)
+	if !re.MatchString(string(bytes)) {
+		return versionField{}, fmt.Errorf("No numeric `%s` found in %s", name, file)
+	}
+
+	return versionField{File: file, Regexp: re}, nil
+}
+
+// flavorVersionCodeRegexp matches the versionCode of a `flavor { ... }` block without nested blocks.
+func flavorVersionCodeRegexp(flavor string) *regexp.Regexp {
+	return regexp.MustCompile(`(?s)\b` + regexp.QuoteMeta(flavor) + `\s*\{[^{}]*?versionCode\s+(\d+)`)
+}
+
+func locateFlavorVersionCode(file, flavor string) (versionField, error) {
+	bytes, err := readVersionFile(file)
+	if err != nil {
+		return versionField{}, err
+	}
+
+	re := flavorVersionCodeRegexp(flavor)
+	if !re.MatchString(string(bytes)) {
+		return versionField{}, fmt.Errorf("No `%s { versionCode N }` block found in %s", flavor, file)
+	}
+
+	return versionField{File: file, Regexp: re}, nil
+}
+
+func getVersionNameFromFile(configs ConfigsModel, file string) (string, error) {
+	field, err := locateVersionName(configs, file)
+	if err != nil {
+		return "", err
+	}
+
+	return field.read()
+}
+
+// getVersionCodeFromFile returns the versionCode and the number of digits it is written with.
+func getVersionCodeFromFile(configs ConfigsModel, file string) (int, int, error) {
+	field, err := locateVersionCode(configs, file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, err := field.read()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	versionCode, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(versionCode), len(value), nil
+}
+
+// formatVersionCode re-pads the code to the width it was read with when preserve_leading_zeros is set.
+func formatVersionCode(configs ConfigsModel, code, width int) string {
+	if configs.PreserveLeadingZeros == "true" {
+		return fmt.Sprintf("%0*d", width, code)
+	}
+
+	return strconv.Itoa(code)
+}
+
+func getVersionsFromFiles(configs ConfigsModel, codeFile, nameFile string) (Versions, error) {
+	name, err := getVersionNameFromFile(configs, nameFile)
+	if errors.Is(err, ErrVersionNameNotFound) && configs.RequireVersionName == "false" {
+		name = ""
+	} else if err != nil {
+		return Versions{}, err
+	}
+
+	code, width, err := getVersionCodeFromFile(configs, codeFile)
+	if err != nil {
+		return Versions{}, err
+	}
+
+	extraCode := 0
+	if configs.ExtraCodeField != "" {
+		field, err := locateExtraCode(codeFile, configs.ExtraCodeField)
+		if err != nil {
+			return Versions{}, err
+		}
+		value, err := field.read()
+		if err != nil {
+			return Versions{}, err
+		}
+		if extraCode, err = strconv.Atoi(value); err != nil {
+			return Versions{}, err
+		}
+	}
+
+	return Versions{
+		Name:      name,
+		Code:      code,
+		ExtraCode: extraCode,
+		codeWidth: width,
+	}, nil
+}
+
+func getVersionsFromFile(configs ConfigsModel, file string) (Versions, error) {
+	return getVersionsFromFiles(configs, file, file)
+}
+
+// replace writes the value into the body, into every match of Regexp or the located XML value.
+func (field versionField) replace(body, value string) (string, error) {
+	if field.XML == nil {
+		return replaceSubmatch(field.Regexp, body, value), nil
+	}
+
+	start, end, err := field.XML.locate(body)
+	if err != nil {
+		return "", fmt.Errorf("Failed to locate %s in %s: %s", field.XML, field.File, err)
+	}
+
+	return body[:start] + value + body[end:], nil
+}
+
+// replaceSubmatch replaces the first capture group of every match, keeping the text around it.
+func replaceSubmatch(re *regexp.Regexp, body, value string) string {
+	result := ""
+	last := 0
+	for _, indexes := range re.FindAllStringSubmatchIndex(body, -1) {
+		result += body[last:indexes[2]] + value
+		last = indexes[3]
+	}
+
+	return result + body[last:]
+}
+
+func writeFile(configs ConfigsModel, file, body string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	mode := info.Mode().Perm()
+	if configs.MakeWritable == "true" && mode&0200 == 0 {
+		log.Warn("%s is read-only, making it writable for the bump", file)
+		if err := os.Chmod(file, mode|0200); err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.Chmod(file, mode); err != nil {
+				log.Warn("Failed to restore mode %s of %s: %s", mode, file, err)
+			}
+		}()
+	}
+
+	// the existing file keeps its mode, e.g. 0664 in group-shared checkouts
+	if err := ioutil.WriteFile(file, []byte(body), mode); err != nil {
+		if os.IsPermission(err) {
+			return ErrFileNotWritable
+		}
+		return err
+	}
+
+	return nil
+}
+
+// writeFields writes each value into its field, rewriting every affected file once.
+func writeFields(configs ConfigsModel, fields []versionField, values []string) error {
+	files := []string{}
+	bodies := map[string]string{}
+	for i, field := range fields {
+		body, ok := bodies[field.File]
+		if !ok {
+			bytes, err := ioutil.ReadFile(field.File)
+			if err != nil {
+				return err
+			}
+			body = string(bytes)
+			files = append(files, field.File)
+		}
+
+		replaced, err := field.replace(body, values[i])
+		if err != nil {
+			return err
+		}
+		bodies[field.File] = replaced
+	}
+
+	for _, file := range files {
+		if err := writeFile(configs, file, bodies[file]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionFieldFiles returns the files that are rewritten when bumping the given code and name files.
+func versionFieldFiles(configs ConfigsModel, codeFile, nameFile string) ([]string, error) {
+	nameField, err := locateVersionName(configs, nameFile)
+	if errors.Is(err, ErrVersionNameNotFound) && configs.RequireVersionName == "false" {
+		return []string{codeFile}, nil
+	} else if err != nil {
+		return []string{}, err
+	}
+
+	if nameField.File == codeFile {
+		return []string{codeFile}, nil
+	}
+
+	return []string{codeFile, nameField.File}, nil
+}
+
+// setVersionsToFiles writes the new versions while holding a lock on the files,
+// failing if another run changed them since the old versions were read.
+func setVersionsToFiles(configs ConfigsModel, codeFile, nameFile string, old, versions Versions) error {
+	timeout, err := configs.lockTimeout()
+	if err != nil {
+		return err
+	}
+
+	files, err := versionFieldFiles(configs, codeFile, nameFile)
+	if err != nil {
+		return err
+	}
+
+	locks, err := lockFiles(files, timeout)
+	if err != nil {
+		return err
+	}
+	defer unlockFiles(locks)
+
+	current, err := getVersionsFromFiles(configs, codeFile, nameFile)
+	if err != nil {
+		return err
+	}
+	if current.Name != old.Name || current.Code != old.Code || current.ExtraCode != old.ExtraCode {
+		return fmt.Errorf("Versions changed concurrently from %s (%d) to %s (%d), run the bump again", old.Name, old.Code, current.Name, current.Code)
+	}
+
+	codeField, err := locateVersionCode(configs, codeFile)
+	if err != nil {
+		return err
+	}
+
+	if configs.StripMetadataOnWrite == "true" {
+		// the tag and outputs keep the metadata, only the file gets the clean versionName
+		versions.Name = strings.SplitN(versions.Name, "+", 2)[0]
+	}
+
+	fields := []versionField{codeField}
+	values := []string{formatVersionCode(configs, versions.Code, versions.codeWidth)}
+
+	if configs.ExtraCodeField != "" {
+		field, err := locateExtraCode(codeFile, configs.ExtraCodeField)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, field)
+		values = append(values, strconv.Itoa(versions.ExtraCode))
+	}
+
+	if old.Name != "" {
+		nameField, err := locateVersionName(configs, nameFile)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, nameField)
+		values = append(values, versions.Name)
+	}
+
+	// flavor codes are written after the base code, which replaces every plain versionCode
+	for flavor, code := range versions.FlavorCodes {
+		field, err := locateFlavorVersionCode(codeFile, flavor)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, field)
+		values = append(values, formatVersionCode(configs, code, versions.codeWidth))
+	}
+
+	originals := map[string]string{}
+	for _, file := range files {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		originals[file] = string(bytes)
+	}
+
+	if err := writeFields(configs, fields, values); err != nil {
+		return err
+	}
+
+	if err := verifyVersions(configs, codeFile, nameFile, versions); err != nil {
+		for _, file := range files {
+			if restoreErr := writeFile(configs, file, originals[file]); restoreErr != nil {
+				log.Warn("Failed to restore %s: %s", file, restoreErr)
+			}
+		}
+		return fmt.Errorf("%s, the original files were restored", err)
+	}
+
+	return nil
+}
+
+// verifyVersions reads the written files back, so a bad replacement fails before anything is committed.
+func verifyVersions(configs ConfigsModel, codeFile, nameFile string, versions Versions) error {
+	written, err := getVersionsFromFiles(configs, codeFile, nameFile)
+	if err != nil {
+		return fmt.Errorf("Written files no longer parse: %s", err)
+	}
+	if written.Name != versions.Name || written.Code != versions.Code {
+		return fmt.Errorf("Written files read back as %s (%d) instead of %s (%d)", written.Name, written.Code, versions.Name, versions.Code)
+	}
+	if written.ExtraCode != versions.ExtraCode {
+		return fmt.Errorf("Written %s reads back as %d instead of %d", configs.ExtraCodeField, written.ExtraCode, versions.ExtraCode)
+	}
+
+	for flavor, code := range versions.FlavorCodes {
+		field, err := locateFlavorVersionCode(codeFile, flavor)
+		if err != nil {
+			return err
+		}
+		value, err := field.read()
+		if err != nil {
+			return err
+		}
+		if writtenCode, err := strconv.Atoi(value); err != nil || writtenCode != code {
+			return fmt.Errorf("Written versionCode of flavor %s reads back as %s instead of %d", flavor, value, code)
+		}
+	}
+
+	return nil
+}
+
+func setVersionsToFile(configs ConfigsModel, file string, old, versions Versions) error {
+	return setVersionsToFiles(configs, file, file, old, versions)
+}
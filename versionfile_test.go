@@ -0,0 +1,607 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileMakeWritable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "build.gradle")
+	writeFixture(t, file, buildGradleFixture)
+	if err := os.Chmod(file, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(testConfigs(t, map[string]string{"make_writable": "true"}), file, "bumped\n"); err != nil {
+		t.Fatalf("writeFile() = %s", err)
+	}
+
+	if content := readFixture(t, file); content != "bumped\n" {
+		t.Errorf("content = %q, want bumped", content)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0444 {
+		t.Errorf("mode = %s, want the read-only mode restored", mode)
+	}
+}
+
+func TestWriteFileReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write read-only files")
+	}
+
+	file := filepath.Join(t.TempDir(), "build.gradle")
+	writeFixture(t, file, buildGradleFixture)
+	if err := os.Chmod(file, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFile(testConfigs(t, nil), file, "bumped\n")
+	if !errors.Is(err, ErrFileNotWritable) {
+		t.Fatalf("writeFile() error = %v, want %v", err, ErrFileNotWritable)
+	}
+	if hintFor(err) == "" {
+		t.Errorf("no hint for %v", err)
+	}
+	if content := readFixture(t, file); content != buildGradleFixture {
+		t.Errorf("read-only file changed to %q", content)
+	}
+}
+
+func TestSplitCodeAndNameFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", "android {\n    defaultConfig {\n        versionCode 12\n    }\n}\n")
+	writeFixture(t, "gradle.properties", "org.gradle.jvmargs=-Xmx2g\nversionName=2.0.0\n")
+	configs := testConfigs(t, map[string]string{"code_file": "app/build.gradle", "name_file": "gradle.properties"})
+
+	files, err := findBuildGradleFiles(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeFile, nameFile := configs.versionFiles(files[0])
+	if codeFile != "app/build.gradle" || nameFile != "gradle.properties" {
+		t.Fatalf("versionFiles() = %s, %s", codeFile, nameFile)
+	}
+
+	versions, err := getVersionsFromFiles(configs, codeFile, nameFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "2.0.0" || versions.Code != 12 {
+		t.Fatalf("getVersionsFromFiles() = %s (%d), want 2.0.0 (12)", versions.Name, versions.Code)
+	}
+
+	newVersions, err := bumpVersions(configs, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setVersionsToFiles(configs, codeFile, nameFile, versions, newVersions); err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readFixture(t, "app/build.gradle"); content != "android {\n    defaultConfig {\n        versionCode 13\n    }\n}\n" {
+		t.Errorf("app/build.gradle = %q", content)
+	}
+	if content := readFixture(t, "gradle.properties"); content != "org.gradle.jvmargs=-Xmx2g\nversionName=2.0.1\n" {
+		t.Errorf("gradle.properties = %q", content)
+	}
+}
+
+func TestSameCodeAndNameFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	configs := testConfigs(t, map[string]string{"code_file": "app/build.gradle", "name_file": "app/build.gradle"})
+
+	files, err := versionFieldFiles(configs, "app/build.gradle", "app/build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("versionFieldFiles() = %v, want the single file", files)
+	}
+
+	if err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", Versions{Name: "1.2.3", Code: 12}, Versions{Name: "1.2.4", Code: 13}); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := getVersionsFromFile(configs, "app/build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.4" || versions.Code != 13 {
+		t.Errorf("getVersionsFromFile() = %s (%d), want 1.2.4 (13)", versions.Name, versions.Code)
+	}
+}
+
+func TestLocateVariable(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/versions.gradle", "ext {\n    baseVersion = \"2.0.0\"\n}\n")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		file    string
+	}{
+		{"def", "def appVersion = \"1.2.3\"\nandroid { defaultConfig { versionName appVersion } }\n", "1.2.3", "app/build.gradle"},
+		{"ext property", "ext.appVersion = '1.2.3'\nandroid { defaultConfig { versionName \"${appVersion}\" } }\n", "1.2.3", "app/build.gradle"},
+		{"ext block", "ext {\n    appVersion = \"1.2.3\"\n}\nandroid { defaultConfig { versionName \"$appVersion\" } }\n", "1.2.3", "app/build.gradle"},
+		{"applied file", "apply from: 'versions.gradle'\nandroid { defaultConfig { versionName rootProject.ext.baseVersion } }\n", "2.0.0", "app/versions.gradle"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeFixture(t, "app/build.gradle", test.content+"versionCode 12\n")
+			configs := testConfigs(t, nil)
+
+			field, err := locateVersionName(configs, "app/build.gradle")
+			if err != nil {
+				t.Fatalf("locateVersionName() = %s", err)
+			}
+			if field.File != test.file {
+				t.Errorf("field file = %s, want %s", field.File, test.file)
+			}
+			if value, err := field.read(); err != nil || value != test.want {
+				t.Errorf("field value = %q, %v, want %s", value, err, test.want)
+			}
+
+			if err := setVersionsToFile(configs, "app/build.gradle", Versions{Name: test.want, Code: 12}, Versions{Name: "3.0.0", Code: 13}); err != nil {
+				t.Fatalf("setVersionsToFile() = %s", err)
+			}
+			if !strings.Contains(readFixture(t, test.file), `"3.0.0"`) && !strings.Contains(readFixture(t, test.file), `'3.0.0'`) {
+				t.Errorf("%s = %q, want the variable set to 3.0.0", test.file, readFixture(t, test.file))
+			}
+			writeFixture(t, "app/versions.gradle", "ext {\n    baseVersion = \"2.0.0\"\n}\n")
+		})
+	}
+}
+
+func TestLocateVariableMissingDefinition(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "build.gradle", "apply from: 'versions.gradle'\nversionName appVersion\nversionCode 12\n")
+
+	_, err := locateVersionName(testConfigs(t, nil), "build.gradle")
+	if err == nil || !strings.Contains(err.Error(), "build.gradle, versions.gradle") {
+		t.Errorf("locateVersionName() error = %v, want the searched files listed", err)
+	}
+}
+
+func TestFlavorVersionCodes(t *testing.T) {
+	tests := []struct {
+		flavor string
+		match  bool
+		code   string
+	}{
+		{"free", true, "100"},
+		{"paid", true, "200"},
+		{"pro", false, ""},
+		{"fre", false, ""},
+	}
+
+	content := "android {\n    defaultConfig {\n        versionCode 12\n        versionName \"1.2.3\"\n    }\n    productFlavors {\n        free {\n            applicationIdSuffix \".free\"\n            versionCode 100\n        }\n        paid { versionCode 200 }\n    }\n}\n"
+	for _, test := range tests {
+		matches := flavorVersionCodeRegexp(test.flavor).FindStringSubmatch(content)
+		if (matches != nil) != test.match {
+			t.Errorf("flavorVersionCodeRegexp(%s) matches = %v, want %v", test.flavor, matches != nil, test.match)
+		} else if test.match && matches[1] != test.code {
+			t.Errorf("flavorVersionCodeRegexp(%s) code = %s, want %s", test.flavor, matches[1], test.code)
+		}
+	}
+
+	t.Chdir(t.TempDir())
+	writeFixture(t, "build.gradle", content)
+	configs := testConfigs(t, map[string]string{"flavor_offsets": "free=1000,paid=2000"})
+
+	versions, err := getVersionsFromFile(configs, "build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVersions, err := bumpVersions(configs, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersions.FlavorCodes["free"] != 1013 || newVersions.FlavorCodes["paid"] != 2013 {
+		t.Fatalf("FlavorCodes = %v, want free 1013 and paid 2013", newVersions.FlavorCodes)
+	}
+
+	if err := setVersionsToFile(configs, "build.gradle", versions, newVersions); err != nil {
+		t.Fatalf("setVersionsToFile() = %s", err)
+	}
+	written := readFixture(t, "build.gradle")
+	for _, want := range []string{"versionCode 13\n", "versionCode 1013\n", "paid { versionCode 2013 }"} {
+		if !strings.Contains(written, want) {
+			t.Errorf("build.gradle = %q, want %q", written, want)
+		}
+	}
+	if err := verifyVersions(configs, "build.gradle", "build.gradle", newVersions); err != nil {
+		t.Errorf("verifyVersions() = %s", err)
+	}
+
+	newVersions.FlavorCodes["paid"] = 2014
+	if err := verifyVersions(configs, "build.gradle", "build.gradle", newVersions); err == nil {
+		t.Error("verifyVersions() = nil, want the mismatched flavor code reported")
+	}
+}
+
+func TestBuildSrcVersions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const fixture = `object Versions {
+    const val kotlin = "1.9.0"
+    const val versionCode: Int = 5
+    const  val  versionName = "1.2.3"
+}
+`
+	writeFixture(t, "buildSrc/src/main/kotlin/Versions.kt", fixture)
+	configs := testConfigs(t, map[string]string{"version_source": "buildsrc"})
+
+	files, err := findBuildGradleFiles(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "buildSrc/src/main/kotlin/Versions.kt" {
+		t.Fatalf("findBuildGradleFiles() = %v, want the Versions.kt", files)
+	}
+
+	versions, err := getVersionsFromFile(configs, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.3" || versions.Code != 5 {
+		t.Fatalf("getVersionsFromFile() = %s (%d), want 1.2.3 (5)", versions.Name, versions.Code)
+	}
+
+	if err := setVersionsToFiles(configs, files[0], files[0], versions, Versions{Name: "1.2.4", Code: 6}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(`versionCode: Int = 5`, `versionCode: Int = 6`, `"1.2.3"`, `"1.2.4"`).Replace(fixture)
+	if content := readFixture(t, files[0]); content != want {
+		t.Errorf("Versions.kt =\n%s\nwant\n%s", content, want)
+	}
+}
+
+func TestConcatenatedVersionName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", strings.Replace(buildGradleFixture, `versionName "1.2.3"`, `versionName "1.2." + patchNumber`, 1))
+
+	_, err := getVersionsFromFile(testConfigs(t, nil), "app/build.gradle")
+	if !errors.Is(err, ErrVersionNameConcatenated) {
+		t.Errorf("getVersionsFromFile() error = %v, want %v", err, ErrVersionNameConcatenated)
+	}
+}
+
+func TestPreserveLeadingZeros(t *testing.T) {
+	for _, test := range []struct {
+		code     string
+		preserve string
+		want     string
+	}{
+		{"007", "true", "versionCode 008"},
+		{"009", "true", "versionCode 010"},
+		{"999", "true", "versionCode 1000"},
+		{"007", "false", "versionCode 8"},
+		{"12", "true", "versionCode 13"},
+	} {
+		t.Chdir(t.TempDir())
+		writeFixture(t, "app/build.gradle", strings.Replace(buildGradleFixture, "versionCode 12", "versionCode "+test.code, 1))
+		configs := testConfigs(t, map[string]string{"preserve_leading_zeros": test.preserve})
+
+		versions, err := getVersionsFromFile(configs, "app/build.gradle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		newVersions, err := bumpVersions(configs, versions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", versions, newVersions); err != nil {
+			t.Fatal(err)
+		}
+
+		if content := readFixture(t, "app/build.gradle"); !strings.Contains(content, "        "+test.want+"\n") {
+			t.Errorf("versionCode %s with preserve_leading_zeros %s written as:\n%s\nwant %s", test.code, test.preserve, content, test.want)
+		}
+	}
+}
+
+func TestSetVersionsToFilesRestoresOnReadBackMismatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+	configs := testConfigs(t, nil)
+
+	// a replacement gone wrong: the written versionCode reads back as another one
+	read := readVersionFile
+	readVersionFile = func(file string) ([]byte, error) {
+		bytes, err := read(file)
+		return []byte(strings.Replace(string(bytes), "versionCode 13", "versionCode 31", 1)), err
+	}
+	t.Cleanup(func() { readVersionFile = read })
+
+	err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", Versions{Name: "1.2.3", Code: 12}, Versions{Name: "1.2.4", Code: 13})
+	if err == nil || !strings.Contains(err.Error(), "read back as 1.2.4 (31) instead of 1.2.4 (13)") || !strings.Contains(err.Error(), "restored") {
+		t.Fatalf("setVersionsToFiles() error = %v, want the read back mismatch", err)
+	}
+	if content := readFixture(t, "app/build.gradle"); content != buildGradleFixture {
+		t.Errorf("app/build.gradle not restored:\n%s", content)
+	}
+}
+
+func TestVersionFieldsWithComments(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "build.gradle")
+	writeFixture(t, file, "android {\n    defaultConfig {\n        versionCode // ci\n            12\n        versionName /* rc */ \"1.2.3\"\n    }\n}\n")
+	configs := testConfigs(t, nil)
+
+	versions, err := getVersionsFromFile(configs, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.3" || versions.Code != 12 {
+		t.Fatalf("getVersionsFromFile() = %s (%d), want 1.2.3 (12)", versions.Name, versions.Code)
+	}
+
+	if err := setVersionsToFile(configs, file, versions, Versions{Name: "1.2.4", Code: 13}); err != nil {
+		t.Fatalf("setVersionsToFile() = %s", err)
+	}
+	want := "android {\n    defaultConfig {\n        versionCode // ci\n            13\n        versionName /* rc */ \"1.2.4\"\n    }\n}\n"
+	if content := readFixture(t, file); content != want {
+		t.Errorf("build.gradle = %q, want %q", content, want)
+	}
+}
+
+func TestPubspecVersions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const fixture = "name: app\ndescription: An app\nversion: 1.2.3+45\n\nenvironment:\n  sdk: \">=3.0.0 <4.0.0\"\n"
+	writeFixture(t, "pubspec.yaml", fixture)
+	writeFixture(t, "android/app/build.gradle", strings.Replace(buildGradleFixture, "versionCode 12", "versionCode 45", 1))
+	configs := testConfigs(t, map[string]string{"version_source": "pubspec", "pubspec_sync_gradle_file": "android/app/build.gradle"})
+
+	versions, err := getVersionsFromFile(configs, "pubspec.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.3" || versions.Code != 45 {
+		t.Fatalf("getVersionsFromFile() = %s (%d), want 1.2.3 (45)", versions.Name, versions.Code)
+	}
+
+	newVersions, err := bumpVersions(configs, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := applyVersions(configs, "pubspec.yaml", "pubspec.yaml", []string{"pubspec.yaml"}, versions, newVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readFixture(t, "pubspec.yaml"); content != strings.Replace(fixture, "1.2.3+45", "1.2.4+46", 1) {
+		t.Errorf("pubspec.yaml =\n%s", content)
+	}
+	if content := readFixture(t, "android/app/build.gradle"); !strings.Contains(content, "versionCode 46") || !strings.Contains(content, `versionName "1.2.4"`) {
+		t.Errorf("synced build.gradle =\n%s", content)
+	}
+	if !equalStrings(files, []string{"pubspec.yaml", "android/app/build.gradle"}) {
+		t.Errorf("applyVersions() changed %v, want the pubspec and the synced gradle file", files)
+	}
+}
+
+func TestSetVersionsToFilesPreservesMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0664, 0600, 0755} {
+		t.Chdir(t.TempDir())
+		writeFixture(t, "app/build.gradle", buildGradleFixture)
+		if err := os.Chmod("app/build.gradle", mode); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := setVersionsToFiles(testConfigs(t, nil), "app/build.gradle", "app/build.gradle", Versions{Name: "1.2.3", Code: 12}, Versions{Name: "1.2.4", Code: 13}); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat("app/build.gradle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("mode after the bump = %s, want %s", info.Mode().Perm(), mode)
+		}
+	}
+}
+
+func TestExtraCodeField(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fixture := strings.Replace(buildGradleFixture, "versionCode 12\n", "versionCode 12\n        buildNumber = 456\n", 1)
+	writeFixture(t, "app/build.gradle", fixture)
+	configs := testConfigs(t, map[string]string{"extra_code_field": "buildNumber"})
+
+	versions, err := getVersionsFromFile(configs, "app/build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.ExtraCode != 456 {
+		t.Fatalf("buildNumber = %d, want 456", versions.ExtraCode)
+	}
+
+	newVersions, err := bumpVersions(configs, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersions.ExtraCode != 457 || newVersions.Code != 13 {
+		t.Fatalf("bumpVersions() = %d (buildNumber %d), want 13 (buildNumber 457)", newVersions.Code, newVersions.ExtraCode)
+	}
+	if err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", versions, newVersions); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.NewReplacer("versionCode 12", "versionCode 13", "buildNumber = 456", "buildNumber = 457", `"1.2.3"`, `"1.2.4"`).Replace(fixture)
+	if content := readFixture(t, "app/build.gradle"); content != want {
+		t.Errorf("app/build.gradle =\n%s\nwant\n%s", content, want)
+	}
+}
+
+func TestExtraCodeFieldMissing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", buildGradleFixture)
+
+	_, err := getVersionsFromFile(testConfigs(t, map[string]string{"extra_code_field": "buildNumber"}), "app/build.gradle")
+	if err == nil || !strings.Contains(err.Error(), "buildNumber") {
+		t.Errorf("getVersionsFromFile() error = %v, want the missing buildNumber", err)
+	}
+}
+
+func TestConditionalVersionName(t *testing.T) {
+	const fixture = `android {
+    defaultConfig {
+        versionCode 12
+        versionName isRelease ? "1.2.3" : "1.2.3-dev"
+    }
+}
+`
+	for _, test := range []struct {
+		branch string
+		want   string
+		err    error
+	}{
+		{"true", `versionName isRelease ? "1.2.4" : "1.2.3-dev"`, nil},
+		{"false", `versionName isRelease ? "1.2.3" : "1.2.4"`, nil},
+		{"", "", ErrVersionNameConditional},
+	} {
+		t.Chdir(t.TempDir())
+		writeFixture(t, "app/build.gradle", fixture)
+		configs := testConfigs(t, map[string]string{"conditional_branch": test.branch})
+
+		versions, err := getVersionsFromFile(configs, "app/build.gradle")
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("getVersionsFromFile() with conditional_branch %q error = %v, want %v", test.branch, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newVersions, err := bumpVersions(configs, versions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", versions, newVersions); err != nil {
+			t.Fatal(err)
+		}
+		if content := readFixture(t, "app/build.gradle"); !strings.Contains(content, "        "+test.want+"\n") {
+			t.Errorf("conditional_branch %s wrote:\n%s\nwant %s", test.branch, content, test.want)
+		}
+	}
+}
+
+func TestVersionNameExpressionsInComments(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fixture := strings.Replace(buildGradleFixture, "        versionName", `        // TODO: should versionName come from CI?
+        // versionName base + ".3" was too clever
+        /* versionName isRelease ? "1.0.0" : "1.0.0-dev" */
+        versionName`, 1)
+	writeFixture(t, "app/build.gradle", fixture)
+	configs := testConfigs(t, nil)
+
+	versions, err := getVersionsFromFile(configs, "app/build.gradle")
+	if err != nil {
+		t.Fatalf("getVersionsFromFile() = %s, want the comments ignored", err)
+	}
+	if versions.Name != "1.2.3" {
+		t.Errorf("versionName = %s, want 1.2.3", versions.Name)
+	}
+}
+
+func TestPropertiesVersionsWithCustomKeys(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const fixture = "# shared with the iOS build\nMARKETING_VERSION = 1.2.3\napp.version.name.legacy=0.9.0\nCURRENT_PROJECT_VERSION: 12\n"
+	writeFixture(t, "version.properties", fixture)
+	configs := testConfigs(t, map[string]string{
+		"version_source":      "properties",
+		"properties_name_key": "MARKETING_VERSION",
+		"properties_code_key": "CURRENT_PROJECT_VERSION",
+	})
+	if _, err := configs.validate(); err != nil {
+		t.Fatalf("validate() = %s", err)
+	}
+
+	files, err := findBuildGradleFiles(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "version.properties" {
+		t.Fatalf("findBuildGradleFiles() = %v, want the version.properties", files)
+	}
+
+	versions, err := getVersionsFromFile(configs, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.3" || versions.Code != 12 {
+		t.Fatalf("getVersionsFromFile() = %s (%d), want 1.2.3 (12)", versions.Name, versions.Code)
+	}
+
+	if err := setVersionsToFiles(configs, files[0], files[0], versions, Versions{Name: "1.3.0", Code: 13}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer("= 1.2.3", "= 1.3.0", ": 12", ": 13").Replace(fixture)
+	if content := readFixture(t, files[0]); content != want {
+		t.Errorf("version.properties = %q, want %q", content, want)
+	}
+
+	for _, keys := range []map[string]string{
+		{"properties_name_key": "VERSION NAME"},
+		{"properties_name_key": "VERSION", "properties_code_key": "VERSION"},
+	} {
+		keys["version_source"] = "properties"
+		if _, err := testConfigs(t, keys).validate(); err == nil {
+			t.Errorf("validate() accepted %v", keys)
+		}
+	}
+}
+
+func TestConflictingVersionNames(t *testing.T) {
+	const fixture = `android {
+    defaultConfig {
+        versionCode 12
+    }
+    productFlavors {
+        free {
+            versionName "1.2.3"
+        }
+        paid {
+            versionName "%s"
+        }
+    }
+}
+`
+	t.Chdir(t.TempDir())
+	writeFixture(t, "app/build.gradle", strings.Replace(fixture, "%s", "1.3.0", 1))
+	configs := testConfigs(t, nil)
+
+	_, err := getVersionsFromFile(configs, "app/build.gradle")
+	if !errors.Is(err, ErrVersionNameConflicting) {
+		t.Fatalf("getVersionsFromFile() error = %v, want %v", err, ErrVersionNameConflicting)
+	}
+	if !strings.Contains(err.Error(), `"1.2.3" at app/build.gradle:7, "1.3.0" at app/build.gradle:10`) {
+		t.Errorf("conflicting values not listed: %s", err)
+	}
+	if hintFor(err) == "" {
+		t.Errorf("no hint for %v", err)
+	}
+
+	// the same value in every flavor is bumped everywhere
+	writeFixture(t, "app/build.gradle", strings.Replace(fixture, "%s", "1.2.3", 1))
+	versions, err := getVersionsFromFile(configs, "app/build.gradle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setVersionsToFiles(configs, "app/build.gradle", "app/build.gradle", versions, Versions{Name: "1.2.4", Code: 13}); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFixture(t, "app/build.gradle"); strings.Count(content, `versionName "1.2.4"`) != 2 {
+		t.Errorf("app/build.gradle =\n%s\nwant both flavors bumped", content)
+	}
+}
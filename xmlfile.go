@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func isXMLFile(file string) bool {
+	return filepath.Ext(file) == ".xml"
+}
+
+// xmlSelectorRegexp matches `element` and `element[@attribute=value]`, the value optionally quoted.
+var xmlSelectorRegexp = regexp.MustCompile(`^([A-Za-z_][\w.-]*)(?:\[@([A-Za-z_][\w.-]*)=(?:"([^"]*)"|'([^']*)'|([^\]"']*))\])?$`)
+
+var errXMLElementNotFound = errors.New("No matching element")
+
+// xmlField is a version value in the text or an attribute of the first element matching a selector,
+// e.g. the text of `string[@name=version_name]` in `<string name="version_name">1.2.3</string>`.
+type xmlField struct {
+	Element        string
+	FilterName     string
+	FilterValue    string
+	ValueAttribute string
+}
+
+func parseXMLSelector(selector, attribute string) (*xmlField, error) {
+	matches := xmlSelectorRegexp.FindStringSubmatch(selector)
+	if matches == nil {
+		return nil, fmt.Errorf("XML selector %q must be element or element[@attribute=value]", selector)
+	}
+
+	return &xmlField{
+		Element:        matches[1],
+		FilterName:     matches[2],
+		FilterValue:    matches[3] + matches[4] + matches[5],
+		ValueAttribute: attribute,
+	}, nil
+}
+
+func (field xmlField) String() string {
+	selector := field.Element
+	if field.FilterName != "" {
+		selector += fmt.Sprintf("[@%s=%s]", field.FilterName, field.FilterValue)
+	}
+	if field.ValueAttribute != "" {
+		selector += "/@" + field.ValueAttribute
+	}
+
+	return selector
+}
+
+func (field xmlField) matches(element xml.StartElement) bool {
+	if element.Name.Local != field.Element {
+		return false
+	}
+	if field.FilterName == "" {
+		return true
+	}
+	for _, attribute := range element.Attr {
+		if attribute.Name.Local == field.FilterName && attribute.Value == field.FilterValue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// locate decodes the content and returns the byte range of the value, so writing it
+// replaces only the value and keeps the rest of the file as is.
+func (field xmlField) locate(content string) (int, int, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		start := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return 0, 0, errXMLElementNotFound
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || !field.matches(element) {
+			continue
+		}
+		if field.ValueAttribute != "" {
+			return field.locateAttribute(content, start, int(decoder.InputOffset()))
+		}
+
+		return field.locateText(decoder, content)
+	}
+}
+
+func (field xmlField) locateAttribute(content string, start, end int) (int, int, error) {
+	re := regexp.MustCompile(`\s` + regexp.QuoteMeta(field.ValueAttribute) + `\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	indexes := re.FindStringSubmatchIndex(content[start:end])
+	if indexes == nil {
+		return 0, 0, fmt.Errorf("Element %s has no attribute %s", field.Element, field.ValueAttribute)
+	}
+	if indexes[2] == -1 {
+		// single quoted
+		return start + indexes[4], start + indexes[5], nil
+	}
+
+	return start + indexes[2], start + indexes[3], nil
+}
+
+func (field xmlField) locateText(decoder *xml.Decoder, content string) (int, int, error) {
+	start := int(decoder.InputOffset())
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	text, ok := token.(xml.CharData)
+	end := int(decoder.InputOffset())
+	raw := content[start:end]
+	if !ok || strings.TrimSpace(raw) == "" {
+		return 0, 0, fmt.Errorf("Element %s has no text", field.Element)
+	}
+	if string(text) != raw {
+		return 0, 0, fmt.Errorf("Text of element %s must be plain, without entities or CDATA", field.Element)
+	}
+
+	// surrounding whitespace, e.g. of an indented value, is kept
+	start += len(raw) - len(strings.TrimLeft(raw, " \t\r\n"))
+	end -= len(raw) - len(strings.TrimRight(raw, " \t\r\n"))
+
+	return start, end, nil
+}
+
+func locateXMLField(file, content, selector, attribute string, notFound error) (versionField, error) {
+	field, err := parseXMLSelector(selector, attribute)
+	if err != nil {
+		return versionField{}, err
+	}
+
+	if _, _, err := field.locate(content); errors.Is(err, errXMLElementNotFound) {
+		return versionField{}, notFound
+	} else if err != nil {
+		return versionField{}, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return versionField{File: file, XML: field}, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXMLVersions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const fixture = `<?xml version="1.0" encoding="utf-8"?>
+<resources>
+    <!-- <string name="version_name">0.0.1</string> -->
+    <string name="app_name">App &amp; Co</string>
+    <string name="version_name">
+        1.2.3
+    </string>
+    <integer name="version_code">12</integer>
+</resources>
+`
+	writeFixture(t, "app/src/main/res/values/version.xml", fixture)
+	configs := testConfigs(t, map[string]string{"version_source": "xml"})
+
+	files, err := findBuildGradleFiles(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "app/src/main/res/values/version.xml" {
+		t.Fatalf("findBuildGradleFiles() = %v, want the version.xml", files)
+	}
+
+	versions, err := getVersionsFromFile(configs, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions.Name != "1.2.3" || versions.Code != 12 {
+		t.Fatalf("getVersionsFromFile() = %s (%d), want 1.2.3 (12)", versions.Name, versions.Code)
+	}
+
+	if err := setVersionsToFiles(configs, files[0], files[0], versions, Versions{Name: "1.2.4", Code: 13}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer("        1.2.3\n", "        1.2.4\n", ">12<", ">13<").Replace(fixture)
+	if content := readFixture(t, files[0]); content != want {
+		t.Errorf("version.xml =\n%s\nwant\n%s", content, want)
+	}
+}
+
+func TestXMLVersionAttributes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const fixture = "<version name='release' versionName='1.2.3' versionCode=\"12\"/>\n"
+	writeFixture(t, "version.xml", fixture)
+	configs := testConfigs(t, map[string]string{
+		"version_source":     "xml",
+		"xml_file":           "version.xml",
+		"xml_name_element":   "version[@name='release']",
+		"xml_name_attribute": "versionName",
+		"xml_code_element":   "version",
+		"xml_code_attribute": "versionCode",
+	})
+	if _, err := configs.validate(); err != nil {
+		t.Fatalf("validate() = %s", err)
+	}
+
+	versions, err := getVersionsFromFile(configs, "version.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setVersionsToFiles(configs, "version.xml", "version.xml", versions, Versions{Name: "1.3.0", Code: 13}); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFixture(t, "version.xml"); content != "<version name='release' versionName='1.3.0' versionCode=\"13\"/>\n" {
+		t.Errorf("version.xml = %q", content)
+	}
+}
+
+func TestXMLVersionErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, test := range []struct {
+		fixture string
+		err     string
+	}{
+		{"<resources><string name=\"version_name\"><![CDATA[1.2.3]]></string><integer name=\"version_code\">12</integer></resources>", "must be plain"},
+		{"<resources><string name=\"version_name\"></string><integer name=\"version_code\">12</integer></resources>", "has no text"},
+		{"<resources><string name=\"version_name\">1.2.3</string>", "EOF"},
+	} {
+		writeFixture(t, "version.xml", test.fixture)
+		_, err := getVersionsFromFile(testConfigs(t, map[string]string{"version_source": "xml", "xml_file": "version.xml"}), "version.xml")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("getVersionsFromFile(%s) error = %v, want %q", test.fixture, err, test.err)
+		}
+	}
+}